@@ -0,0 +1,34 @@
+package accounts
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of an account deletion
+const (
+	StatusPending     = "pending"      // Waiting for the grace period to end, can be cancelled
+	StatusRunning     = "running"      // A replica is tearing the account down
+	StatusTearingDown = "tearing_down" // Teardown started, waiting for the cluster or a retry
+	StatusCompleted   = "completed"    // Everything removed, the user row is gone
+	StatusCancelled   = "cancelled"    // Cancelled during the grace period
+	StatusFailed      = "failed"       // Gave up after too many attempts, needs an operator
+)
+
+// AccountDeletion tracks the background teardown of an account.
+type AccountDeletion struct {
+	ID              uuid.UUID  `json:"id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	Status          string     `json:"status"`
+	CancelTokenHash string     `json:"-"`
+	ScheduledFor    time.Time  `json:"scheduled_for"`
+	Attempts        int        `json:"attempts"`
+	LastError       string     `json:"last_error,omitempty"`
+	RequestedAt     time.Time  `json:"requested_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+}
+
+type CancelDeletion struct {
+	Token string `json:"token"`
+}
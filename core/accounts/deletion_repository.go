@@ -0,0 +1,252 @@
+package accounts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeletionRepository defines methods for persisting account deletions.
+type DeletionRepository interface {
+	Save(deletion *AccountDeletion) error                                                   // Store a new deletion
+	FindByID(id uuid.UUID) (*AccountDeletion, error)                                        // Retrieve a deletion, nil if missing
+	FindByCancelHash(hash string) (*AccountDeletion, error)                                 // Retrieve a deletion by its cancel token, nil if missing
+	FindOpenForUser(userID uuid.UUID) (*AccountDeletion, error)                             // Retrieve the unfinished deletion of a user, nil if none
+	FindDue(now, staleBefore time.Time, limit int) ([]*AccountDeletion, error)              // Deletions ready for a teardown attempt
+	Claim(id uuid.UUID, now, staleBefore time.Time) (bool, error)                           // Mark a deletion as running, false if another replica has it
+	Cancel(id uuid.UUID, at time.Time) (bool, error)                                        // Cancel a pending deletion, false if it already started
+	Reschedule(id uuid.UUID, at time.Time, attempts int, lastError string) error            // Wait before the next teardown attempt
+	Finish(id uuid.UUID, status string, at time.Time, attempts int, lastError string) error // Record the final status
+	PurgeUser(userID uuid.UUID) error                                                       // Remove a user row and everything stored for it
+}
+
+// MySQLDeletionRepository is the implementation of DeletionRepository using MySQL.
+type MySQLDeletionRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLDeletionRepository creates a new MySQLDeletionRepository.
+func NewMySQLDeletionRepository(db *sql.DB) *MySQLDeletionRepository {
+	return &MySQLDeletionRepository{DB: db}
+}
+
+const deletionColumns = `id, user_id, status, cancel_token_hash, scheduled_for, attempts, last_error, requested_at, finished_at`
+
+// Save stores a new account deletion.
+func (repo *MySQLDeletionRepository) Save(deletion *AccountDeletion) error {
+	if deletion.ID == uuid.Nil {
+		deletion.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO account_deletions (id, user_id, status, cancel_token_hash, scheduled_for, requested_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := repo.DB.Exec(query, deletion.ID, deletion.UserID, deletion.Status, deletion.CancelTokenHash, deletion.ScheduledFor, deletion.RequestedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save account deletion: %v", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves an account deletion by ID.
+func (repo *MySQLDeletionRepository) FindByID(id uuid.UUID) (*AccountDeletion, error) {
+	return repo.findOne(`SELECT `+deletionColumns+` FROM account_deletions WHERE id = ?`, id)
+}
+
+// FindByCancelHash retrieves an account deletion by the hash of its cancel token.
+func (repo *MySQLDeletionRepository) FindByCancelHash(hash string) (*AccountDeletion, error) {
+	return repo.findOne(`SELECT `+deletionColumns+` FROM account_deletions WHERE cancel_token_hash = ?`, hash)
+}
+
+// FindOpenForUser retrieves the deletion of a user that has not finished yet.
+func (repo *MySQLDeletionRepository) FindOpenForUser(userID uuid.UUID) (*AccountDeletion, error) {
+	query := `SELECT ` + deletionColumns + ` FROM account_deletions WHERE user_id = ? AND finished_at IS NULL LIMIT 1`
+	return repo.findOne(query, userID)
+}
+
+// FindDue lists the deletions whose next attempt is due, including runs
+// abandoned by a replica that stopped before finishing.
+func (repo *MySQLDeletionRepository) FindDue(now, staleBefore time.Time, limit int) ([]*AccountDeletion, error) {
+	query := `
+		SELECT ` + deletionColumns + `
+		FROM account_deletions
+		WHERE (status IN (?, ?) AND scheduled_for <= ?)
+		   OR (status = ? AND claimed_at < ?)
+		ORDER BY scheduled_for
+		LIMIT ?
+	`
+
+	rows, err := repo.DB.Query(query, StatusPending, StatusTearingDown, now, StatusRunning, staleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch due account deletions: %v", err)
+	}
+	defer rows.Close()
+
+	deletions := []*AccountDeletion{}
+	for rows.Next() {
+		deletion, err := scanDeletion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan account deletion: %v", err)
+		}
+		deletions = append(deletions, deletion)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while reading rows: %v", err)
+	}
+
+	return deletions, nil
+}
+
+// Claim marks a due deletion as running. The conditional update makes sure
+// only one replica tears an account down at a time.
+func (repo *MySQLDeletionRepository) Claim(id uuid.UUID, now, staleBefore time.Time) (bool, error) {
+	query := `
+		UPDATE account_deletions
+		SET status = ?, claimed_at = ?
+		WHERE id = ?
+		  AND ((status IN (?, ?) AND scheduled_for <= ?) OR (status = ? AND claimed_at < ?))
+	`
+
+	result, err := repo.DB.Exec(query, StatusRunning, now, id, StatusPending, StatusTearingDown, now, StatusRunning, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim account deletion: %v", err)
+	}
+
+	return rowsAffected(result, "failed to claim account deletion")
+}
+
+// Cancel cancels a deletion that is still in its grace period.
+func (repo *MySQLDeletionRepository) Cancel(id uuid.UUID, at time.Time) (bool, error) {
+	query := `
+		UPDATE account_deletions
+		SET status = ?, finished_at = ?
+		WHERE id = ? AND status = ?
+	`
+
+	result, err := repo.DB.Exec(query, StatusCancelled, at, id, StatusPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel account deletion: %v", err)
+	}
+
+	return rowsAffected(result, "failed to cancel account deletion")
+}
+
+// Reschedule puts a started teardown back in the queue.
+func (repo *MySQLDeletionRepository) Reschedule(id uuid.UUID, at time.Time, attempts int, lastError string) error {
+	query := `
+		UPDATE account_deletions
+		SET status = ?, scheduled_for = ?, attempts = ?, last_error = ?, claimed_at = NULL
+		WHERE id = ?
+	`
+
+	if _, err := repo.DB.Exec(query, StatusTearingDown, at, attempts, nullIfEmpty(lastError), id); err != nil {
+		return fmt.Errorf("failed to reschedule account deletion: %v", err)
+	}
+
+	return nil
+}
+
+// Finish records that a teardown completed or failed for good.
+func (repo *MySQLDeletionRepository) Finish(id uuid.UUID, status string, at time.Time, attempts int, lastError string) error {
+	query := `
+		UPDATE account_deletions
+		SET status = ?, finished_at = ?, attempts = ?, last_error = ?, claimed_at = NULL
+		WHERE id = ?
+	`
+
+	if _, err := repo.DB.Exec(query, status, at, attempts, nullIfEmpty(lastError), id); err != nil {
+		return fmt.Errorf("failed to finish account deletion: %v", err)
+	}
+
+	return nil
+}
+
+// purgedTables hold rows owned by a user. Session revocations are kept on
+// purpose so access tokens issued before the deletion stay rejected.
+var purgedTables = []string{
+	"refresh_tokens",
+	"mfa_recovery_codes",
+	"mfa_settings",
+	"password_reset_tokens",
+	"email_verification_tokens",
+	"personal_access_tokens",
+	"user_identities",
+	"user_login_locations",
+	"user_devices",
+}
+
+// PurgeUser removes a user and everything stored for it in one transaction.
+func (repo *MySQLDeletionRepository) PurgeUser(userID uuid.UUID) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range purgedTables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to purge %s: %v", table, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user purge: %v", err)
+	}
+
+	return nil
+}
+
+func (repo *MySQLDeletionRepository) findOne(query string, args ...interface{}) (*AccountDeletion, error) {
+	deletion, err := scanDeletion(repo.DB.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no deletion is found
+		}
+		return nil, fmt.Errorf("failed to find account deletion: %v", err)
+	}
+	return deletion, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeletion(row rowScanner) (*AccountDeletion, error) {
+	var deletion AccountDeletion
+	var lastError sql.NullString
+
+	err := row.Scan(
+		&deletion.ID, &deletion.UserID, &deletion.Status, &deletion.CancelTokenHash, &deletion.ScheduledFor,
+		&deletion.Attempts, &lastError, &deletion.RequestedAt, &deletion.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deletion.LastError = lastError.String
+	return &deletion, nil
+}
+
+func rowsAffected(result sql.Result, message string) (bool, error) {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", message, err)
+	}
+	return affected == 1, nil
+}
+
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
@@ -0,0 +1,318 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/containers"
+	"shade_web_server/core/mail"
+	"shade_web_server/core/namespace"
+	"shade_web_server/core/users"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultGracePeriod  = 72 * time.Hour
+	MaxTeardownAttempts = 10
+	NamespacePollDelay  = time.Minute      // Wait between checks while a namespace terminates
+	StaleClaimAfter     = 15 * time.Minute // A running teardown older than this was abandoned
+	dueBatchSize        = 20
+)
+
+var (
+	ErrDeletionPending       = errors.New("account deletion already requested")
+	ErrInvalidCancelToken    = errors.New("invalid cancel token")
+	ErrDeletionNotCancelable = errors.New("account deletion already started")
+)
+
+// DeletionService deletes accounts in the background. A request soft deletes
+// the user and its sub-users right away; once the grace period is over the
+// worker removes the containers, the namespace and finally the user rows.
+type DeletionService struct {
+	DeletionRepo   DeletionRepository
+	UserService    *users.UserService
+	Auth           *auth.AuthService
+	PersonalTokens *auth.PersonalAccessTokenService
+	Containers     containers.ContainerRepository
+	Namespaces     *namespace.NamespaceService
+	Mailer         mail.Mailer
+	BaseURL        string        // Frontend URL the cancel link points to
+	GracePeriod    time.Duration // Time left to cancel before the teardown starts
+}
+
+// NewDeletionService initializes DeletionService.
+func NewDeletionService(repo DeletionRepository, authService *auth.AuthService, tokenService *auth.PersonalAccessTokenService, containerRepo containers.ContainerRepository, namespaceService *namespace.NamespaceService, mailer mail.Mailer, baseURL string, gracePeriod time.Duration) *DeletionService {
+	return &DeletionService{
+		DeletionRepo:   repo,
+		UserService:    authService.UserService,
+		Auth:           authService,
+		PersonalTokens: tokenService,
+		Containers:     containerRepo,
+		Namespaces:     namespaceService,
+		Mailer:         mailer,
+		BaseURL:        baseURL,
+		GracePeriod:    gracePeriod,
+	}
+}
+
+// GracePeriodFromEnv reads ACCOUNT_DELETION_GRACE_PERIOD as a Go duration
+// ("72h", "30m"), falling back to DefaultGracePeriod.
+func GracePeriodFromEnv() (time.Duration, error) {
+	value := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")
+	if value == "" {
+		return DefaultGracePeriod, nil
+	}
+
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil || gracePeriod < 0 {
+		return 0, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD %q", value)
+	}
+	return gracePeriod, nil
+}
+
+// RequestDeletion soft deletes the user, signs it out everywhere and
+// schedules the teardown. The returned token cancels the deletion during the
+// grace period; it is also emailed to the user.
+func (s *DeletionService) RequestDeletion(user *users.User) (*AccountDeletion, string, error) {
+	existing, err := s.DeletionRepo.FindOpenForUser(user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing != nil {
+		return nil, "", ErrDeletionPending
+	}
+
+	token, err := generateCancelToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	deletion := &AccountDeletion{
+		UserID:          user.ID,
+		Status:          StatusPending,
+		CancelTokenHash: hashCancelToken(token),
+		ScheduledFor:    now.Add(s.GracePeriod),
+		RequestedAt:     now,
+	}
+	if err := s.DeletionRepo.Save(deletion); err != nil {
+		return nil, "", err
+	}
+
+	// Sub-users lose access together with the namespace they work in
+	accounts, err := s.affectedUsers(user)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, account := range accounts {
+		if err := s.UserService.UserRepo.SetDeletedAt(account.ID, &now); err != nil {
+			return nil, "", err
+		}
+		if err := s.signOut(account.ID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	link := s.BaseURL + "/account/restore?token=" + url.QueryEscape(token)
+
+	err = s.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your Shade account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour Shade account and everything running in it will be deleted on %s. "+
+			"Open the link below before then to keep your account:\n\n%s\n\n"+
+			"If you asked for the deletion, you can ignore this email.\n",
+			user.Name, deletion.ScheduledFor.UTC().Format(time.RFC1123), link),
+	})
+	if err != nil {
+		return deletion, token, fmt.Errorf("deletion scheduled but the email failed: %w", err)
+	}
+
+	return deletion, token, nil
+}
+
+// CancelDeletion restores an account whose teardown has not started yet.
+func (s *DeletionService) CancelDeletion(token string) (*AccountDeletion, error) {
+	deletion, err := s.DeletionRepo.FindByCancelHash(hashCancelToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if deletion == nil {
+		return nil, ErrInvalidCancelToken
+	}
+
+	cancelled, err := s.DeletionRepo.Cancel(deletion.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, ErrDeletionNotCancelable
+	}
+
+	user, err := s.UserService.GetUserByID(deletion.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := s.affectedUsers(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if err := s.UserService.UserRepo.SetDeletedAt(account.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	deletion.Status = StatusCancelled
+	return deletion, nil
+}
+
+// GetDeletion returns the state of a deletion, nil if it does not exist.
+func (s *DeletionService) GetDeletion(id uuid.UUID) (*AccountDeletion, error) {
+	return s.DeletionRepo.FindByID(id)
+}
+
+// ProcessDue runs one teardown attempt for every deletion that is due and
+// returns how many were processed. Several replicas can call it at once,
+// each deletion is claimed by a single one.
+func (s *DeletionService) ProcessDue() (int, error) {
+	now := time.Now()
+	staleBefore := now.Add(-StaleClaimAfter)
+
+	deletions, err := s.DeletionRepo.FindDue(now, staleBefore, dueBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, deletion := range deletions {
+		claimed, err := s.DeletionRepo.Claim(deletion.ID, now, staleBefore)
+		if err != nil {
+			return processed, err
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := s.process(deletion); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+
+	return processed, nil
+}
+
+// process tears an account down and records the outcome. It only returns
+// an error when the outcome itself could not be stored.
+func (s *DeletionService) process(deletion *AccountDeletion) error {
+	done, err := s.teardown(deletion.UserID)
+	now := time.Now()
+
+	switch {
+	case err != nil:
+		attempts := deletion.Attempts + 1
+		if attempts >= MaxTeardownAttempts {
+			return s.DeletionRepo.Finish(deletion.ID, StatusFailed, now, attempts, err.Error())
+		}
+		backoff := time.Duration(attempts*attempts) * time.Minute
+		return s.DeletionRepo.Reschedule(deletion.ID, now.Add(backoff), attempts, err.Error())
+	case !done:
+		// The namespace is still terminating, this is not a failure
+		return s.DeletionRepo.Reschedule(deletion.ID, now.Add(NamespacePollDelay), deletion.Attempts, "")
+	default:
+		return s.DeletionRepo.Finish(deletion.ID, StatusCompleted, now, deletion.Attempts, "")
+	}
+}
+
+// teardown removes what the user owns. It reports false while the cluster
+// is still deleting the namespace; the user rows are only removed once the
+// namespace is confirmed gone.
+func (s *DeletionService) teardown(userID uuid.UUID) (bool, error) {
+	user, err := s.UserService.GetUserByID(userID)
+	if err != nil {
+		return false, err
+	}
+
+	accounts, err := s.affectedUsers(user)
+	if err != nil {
+		return false, err
+	}
+
+	// Tokens may have been created between the request and now by a
+	// session that was already checked, sign out again to be safe
+	for _, account := range accounts {
+		if err := s.signOut(account.ID); err != nil {
+			return false, err
+		}
+	}
+
+	if user.IsRoot() {
+		if err := s.Containers.DeleteAll(user.Namespace()); err != nil {
+			return false, err
+		}
+
+		gone, err := s.Namespaces.DeleteNamespace(user.Namespace())
+		if err != nil {
+			return false, err
+		}
+		if !gone {
+			return false, nil
+		}
+	}
+
+	// Sub-users first, they reference the root user
+	for i := len(accounts) - 1; i >= 0; i-- {
+		if err := s.DeletionRepo.PurgeUser(accounts[i].ID); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// affectedUsers returns the user followed by its sub-users.
+func (s *DeletionService) affectedUsers(user *users.User) ([]*users.User, error) {
+	accounts := []*users.User{user}
+	if !user.IsRoot() {
+		return accounts, nil
+	}
+
+	subUsers, err := s.UserService.GetSubUsers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return append(accounts, subUsers...), nil
+}
+
+// signOut ends every session and revokes every personal access token.
+func (s *DeletionService) signOut(userID uuid.UUID) error {
+	if err := s.Auth.LogoutAll(userID.String()); err != nil {
+		return err
+	}
+	return s.PersonalTokens.RevokeAll(userID)
+}
+
+// generateCancelToken returns 32 random bytes encoded as URL-safe base64.
+func generateCancelToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashCancelToken returns the hex encoded SHA-256 of a cancel token.
+func hashCancelToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -10,3 +10,18 @@ type Login struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+type Refresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type MFACode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type MFALogin struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
@@ -1,62 +1,278 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"shade_web_server/core/users"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute    // Short-lived, refreshed with a refresh token
+	RefreshTokenTTL = 30 * 24 * time.Hour // Lifetime of a single refresh token
+)
+
+// Token types carried in the "typ" claim, only access tokens open the API
+const (
+	TokenTypeAccess       = "access"
+	TokenTypeMFAChallenge = "mfa_challenge"
+	TokenTypeOIDCState    = "oidc_state"
+	TokenTypeElevated     = "elevated"
+)
+
+var (
+	ErrInvalidCredentials  = errors.New("invalid email or password")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrAccountDisabled     = errors.New("account is disabled")
 )
 
-// JWT secret key (keep this safe, ideally load from env)
-var jwtSecret = []byte("your_secret_key")
+// RehashFailureHandler is told when upgrading a password hash at login failed.
+type RehashFailureHandler func(userID uuid.UUID, err error)
 
 // AuthService handles authentication logic.
 type AuthService struct {
 	UserService *users.UserService
+	RefreshRepo RefreshTokenRepository
+	Revocations RevocationStore
+	Keys        *KeyProvider
+
+	rehashFailed RehashFailureHandler
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
 // NewAuthService initializes AuthService.
-func NewAuthService(userService *users.UserService) *AuthService {
-	return &AuthService{UserService: userService}
+func NewAuthService(userService *users.UserService, refreshTokens RefreshTokenRepository, revocations RevocationStore, keys *KeyProvider) *AuthService {
+	return &AuthService{
+		UserService: userService,
+		RefreshRepo: refreshTokens,
+		Revocations: revocations,
+		Keys:        keys,
+	}
 }
 
-// GenerateJWT generates a JWT token for a user.
+// GenerateJWT generates a short-lived access token for a user.
 func (s *AuthService) GenerateJWT(user *users.User) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
-		"user_id": user.ID.String(),
-		"email":   user.Email,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(), // Expires in 24 hours
+		"jti":            uuid.New().String(), // Lets a single token be revoked on logout
+		"typ":            TokenTypeAccess,
+		"user_id":        user.ID.String(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified(), // Required by /container/create
+		"role":           string(user.Role),    // Checked by RequirePermission
+		"namespace":      user.Namespace(),     // The root user's namespace for sub-users
+		"iat":            now.Unix(),
+		"exp":            now.Add(AccessTokenTTL).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return s.Keys.Sign(claims)
+}
+
+// SetRehashFailureHandler registers the function told about failed hash upgrades
+func (s *AuthService) SetRehashFailureHandler(handler RehashFailureHandler) {
+	s.rehashFailed = handler
 }
 
-// AuthenticateUser checks user credentials and returns a JWT token.
-func (s *AuthService) AuthenticateUser(email string, password string) (string, error) {
+// AuthenticateUser checks user credentials and returns the matching user.
+// Tokens are issued separately, since the login may still need a second factor.
+func (s *AuthService) AuthenticateUser(email string, password string) (*users.User, error) {
 	// Look up the user by email
 	user, err := s.UserService.GetUserByEmail(email)
 	if err != nil {
-		return "", errors.New("invalid email or password")
+		// Unknown emails take as long as wrong passwords, or the response
+		// time would tell which emails have an account
+		users.VerifyPassword(s.dummyPasswordHash(), password)
+		return nil, ErrInvalidCredentials
+	}
+
+	// Compare the stored hash with the password provided, bcrypt hashes from
+	// before Argon2id are still accepted
+	match, err := users.VerifyPassword(user.Password, password)
+	if err != nil || !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	if !user.Active() {
+		return nil, ErrAccountDisabled
+	}
+
+	// Only a login knows the plain password, so legacy hashes and hashes with
+	// outdated parameters are upgraded here. A failure is retried next login.
+	if s.UserService.Hasher.NeedsRehash(user.Password) {
+		if err := s.UserService.UpdatePassword(user.ID, password); err != nil && s.rehashFailed != nil {
+			s.rehashFailed(user.ID, err)
+		}
+	}
+
+	return user, nil
+}
+
+// dummyPasswordHash returns the hash of a random password, made once with the
+// configured hasher so checking it costs as much as checking a real hash
+func (s *AuthService) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		password, err := generateOpaqueToken()
+		if err != nil {
+			return
+		}
+		s.dummyHash, _ = s.UserService.Hasher.Hash(password)
+	})
+	return s.dummyHash
+}
+
+// IssueTokens starts a new session for the user with a fresh refresh token family.
+func (s *AuthService) IssueTokens(user *users.User) (*TokenPair, error) {
+	return s.issueTokens(user, uuid.New(), uuid.Nil)
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. Every refresh
+// token can be used once; presenting an already used token revokes its whole
+// family, since either the client or an attacker holds a stolen copy.
+func (s *AuthService) RefreshTokens(refreshToken string) (*TokenPair, error) {
+	stored, err := s.RefreshRepo.FindByHash(hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.RotatedAt != nil {
+		if err := s.RefreshRepo.RevokeFamily(stored.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	rotated, err := s.RefreshRepo.MarkRotated(stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Lost a race against another refresh with the same token
+		if err := s.RefreshRepo.RevokeFamily(stored.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	user, err := s.UserService.GetUserByID(stored.UserID)
+	if err != nil || !user.Active() {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issueTokens(user, stored.FamilyID, stored.DeviceID)
+}
+
+// Logout revokes the access token identified by jti and, when given, the
+// refresh token family that belongs to the same session.
+func (s *AuthService) Logout(userID, jti string, expiresAt time.Time, refreshToken string) error {
+	if err := s.Revocations.RevokeToken(jti, expiresAt); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.RefreshRepo.FindByHash(hashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	// Ignore refresh tokens that belong to somebody else
+	if stored == nil || stored.UserID.String() != userID {
+		return nil
 	}
 
-	// Compare the stored hash with the password provided
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	return s.RefreshRepo.RevokeFamily(stored.FamilyID, time.Now())
+}
+
+// LogoutAll revokes every access and refresh token issued to a user so far.
+func (s *AuthService) LogoutAll(userID string) error {
+	id, err := uuid.Parse(userID)
 	if err != nil {
-		return "", errors.New("invalid email or password")
+		return fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	// iat and revoked_before have second precision. Tokens issued in the
+	// same second as the cut-off stay valid, so the pair ChangePassword
+	// issues right after is never rejected.
+	now := time.Now().Truncate(time.Second)
+
+	if err := s.Revocations.RevokeAllForUser(userID, now); err != nil {
+		return err
 	}
 
-	// erate JWT if password matches
-	token, err := s.GenerateJWT(user)
+	return s.RefreshRepo.RevokeAllForUser(id, now)
+}
+
+// ChangePassword replaces the password of a user who knows the current one.
+// Every other session is logged out, and the caller gets a fresh token pair
+// to stay logged in.
+func (s *AuthService) ChangePassword(userID uuid.UUID, currentPassword, newPassword string) (*TokenPair, error) {
+	user, err := s.UserService.GetUserByID(userID)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if _, err := s.AuthenticateUser(user.Email, currentPassword); err != nil {
+		return nil, err
+	}
+
+	if len(newPassword) < MinPasswordLength {
+		return nil, ErrWeakPassword
 	}
 
-	return token, nil
+	if err := s.UserService.UpdatePassword(userID, newPassword); err != nil {
+		return nil, err
+	}
+
+	if err := s.LogoutAll(userID.String()); err != nil {
+		return nil, err
+	}
+
+	// Issued after the cut-off, which LogoutAll truncates to the second
+	return s.IssueTokens(user)
+}
+
+// IsTokenRevoked reports whether an access token was revoked, either on its
+// own or because its user logged out of every session after it was issued.
+func IsTokenRevoked(store RevocationStore, userID, jti string, issuedAt time.Time) (bool, error) {
+	revoked, err := store.IsTokenRevoked(jti)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	before, err := store.RevokedBefore(userID)
+	if err != nil {
+		return false, err
+	}
+
+	// Token timestamps have second precision
+	return !before.IsZero() && issuedAt.Unix() < before.Unix(), nil
 }
 
 func (s *AuthService) GetUserID(email string) (string, error) {
@@ -67,3 +283,51 @@ func (s *AuthService) GetUserID(email string) (string, error) {
 
 	return user.ID.String(), nil
 }
+
+// issueTokens creates an access token and a refresh token belonging to the
+// given family and device.
+func (s *AuthService) issueTokens(user *users.User, familyID uuid.UUID, deviceID uuid.UUID) (*TokenPair, error) {
+	accessToken, err := s.GenerateJWT(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.RefreshRepo.Save(&RefreshToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		DeviceID:  deviceID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+		FamilyID:     familyID,
+	}, nil
+}
+
+// generateOpaqueToken returns 32 random bytes encoded as URL-safe base64.
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 of an opaque token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
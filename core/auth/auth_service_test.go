@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"shade_web_server/core/users"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// countingHasher counts the hashes made, with parameters cheap enough for tests
+type countingHasher struct {
+	*users.Argon2idHasher
+	hashes int
+}
+
+func (h *countingHasher) Hash(password string) (string, error) {
+	h.hashes++
+	return h.Argon2idHasher.Hash(password)
+}
+
+func TestAuthenticateUnknownEmailChecksDummyHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	hasher := &countingHasher{Argon2idHasher: users.NewArgon2idHasher(users.Argon2Params{
+		Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+	})}
+	userService := users.NewUserService(users.NewMySQLUserRepository(db))
+	userService.Hasher = hasher
+	authService := NewAuthService(userService, nil, nil, nil)
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM users`).
+			WithArgs("nobody@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		if _, err := authService.AuthenticateUser("nobody@example.com", "password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("AuthenticateUser error = %v, want ErrInvalidCredentials", err)
+		}
+	}
+
+	// The dummy hash is made once with the configured hasher, then reused
+	if hasher.hashes != 1 {
+		t.Errorf("hashed %d times, want 1", hasher.hashes)
+	}
+	if _, err := users.VerifyPassword(authService.dummyPasswordHash(), "password"); err != nil {
+		t.Errorf("dummy hash is not verifiable: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		// present returns the refresh token to exchange, given the pair of a fresh login
+		present       func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string
+		wantErr       error
+		familyRevoked bool
+	}{
+		{
+			name: "unused token rotates",
+			present: func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string {
+				return login.RefreshToken
+			},
+		},
+		{
+			name: "reused token revokes the family",
+			present: func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string {
+				if _, err := s.RefreshTokens(login.RefreshToken); err != nil {
+					t.Fatalf("first refresh: %v", err)
+				}
+				return login.RefreshToken
+			},
+			wantErr:       ErrRefreshTokenReused,
+			familyRevoked: true,
+		},
+		{
+			name: "concurrent refresh revokes the family",
+			present: func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string {
+				repo.loseRace = true
+				return login.RefreshToken
+			},
+			wantErr:       ErrRefreshTokenReused,
+			familyRevoked: true,
+		},
+		{
+			name: "revoked token",
+			present: func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string {
+				repo.RevokeFamily(login.FamilyID, time.Now())
+				return login.RefreshToken
+			},
+			wantErr:       ErrInvalidRefreshToken,
+			familyRevoked: true,
+		},
+		{
+			name: "expired token",
+			present: func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string {
+				for _, token := range repo.tokens {
+					token.ExpiresAt = time.Now().Add(-time.Second)
+				}
+				return login.RefreshToken
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "unknown token",
+			present: func(t *testing.T, s *AuthService, repo *memoryRefreshTokens, login *TokenPair) string {
+				return "unknown"
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &users.User{ID: uuid.New(), Email: "user@example.com", Role: users.RoleOwner}
+			repo := newMemoryRefreshTokens()
+			authService := NewAuthService(users.NewUserService(newMemoryUsers(user)), repo, NewMemoryRevocationStore(), newTestKeys(t))
+
+			login, err := authService.IssueTokens(user)
+			if err != nil {
+				t.Fatalf("IssueTokens: %v", err)
+			}
+			deviceID := uuid.New()
+			repo.AttachDevice(login.FamilyID, deviceID)
+
+			tokens, err := authService.RefreshTokens(tt.present(t, authService, repo, login))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RefreshTokens error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil {
+				// The new token continues the session on the same device
+				if tokens.FamilyID != login.FamilyID {
+					t.Errorf("rotated into family %v, want %v", tokens.FamilyID, login.FamilyID)
+				}
+				rotated, _ := repo.FindByHash(hashToken(tokens.RefreshToken))
+				if rotated.DeviceID != deviceID {
+					t.Errorf("rotated token lost its device")
+				}
+			}
+
+			for _, token := range repo.tokens {
+				if revoked := token.RevokedAt != nil; revoked != tt.familyRevoked {
+					t.Errorf("token revoked = %v, want %v", revoked, tt.familyRevoked)
+				}
+			}
+		})
+	}
+}
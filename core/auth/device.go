@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceCookie holds the random device ID issued to browsers on login.
+const DeviceCookie = "shade_device"
+
+// Device is a browser or client a user logged in from. It is recognised by
+// its device cookie, or by its user agent and network when the cookie is gone.
+type Device struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"-"`
+	Name        string     `json:"name"`
+	CookieHash  string     `json:"-"`
+	Fingerprint string     `json:"-"` // SHA-256 of the user agent and IP prefix
+	UserAgent   string     `json:"user_agent"`
+	IPPrefix    string     `json:"ip_prefix"`
+	LastIP      string     `json:"last_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	Current     bool       `json:"current"` // The device making the request
+}
+
+type RenameDevice struct {
+	Name string `json:"name"`
+}
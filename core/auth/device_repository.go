@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceRepository defines methods for persisting the known devices of users.
+type DeviceRepository interface {
+	Save(device *Device) error                                                            // Store a new device
+	FindByCookie(userID uuid.UUID, cookieHash string) (*Device, error)                    // Active device holding a cookie, nil if none
+	FindByFingerprint(userID uuid.UUID, fingerprint string) (*Device, error)              // Active device with a fingerprint, nil if none
+	FindAllByUser(userID uuid.UUID) ([]*Device, error)                                    // List every device of a user
+	CountAll(userID uuid.UUID) (int, error)                                               // Count the devices ever registered, revoked ones included
+	Touch(id uuid.UUID, cookieHash, fingerprint, ipPrefix, ip string, at time.Time) error // Record a new login from a device
+	Rename(userID, id uuid.UUID, name string) (bool, error)                               // Rename a device of a user, false if not found
+	Revoke(userID, id uuid.UUID, at time.Time) (bool, error)                              // Forget a device of a user, false if not found
+}
+
+// MySQLDeviceRepository is the implementation of DeviceRepository using MySQL.
+type MySQLDeviceRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLDeviceRepository creates a new MySQLDeviceRepository.
+func NewMySQLDeviceRepository(db *sql.DB) *MySQLDeviceRepository {
+	return &MySQLDeviceRepository{DB: db}
+}
+
+const deviceColumns = `id, user_id, name, cookie_hash, fingerprint, user_agent, ip_prefix, last_ip, created_at, last_seen_at, revoked_at`
+
+// Save stores a new device.
+func (repo *MySQLDeviceRepository) Save(device *Device) error {
+	if device.ID == uuid.Nil {
+		device.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO user_devices (id, user_id, name, cookie_hash, fingerprint, user_agent, ip_prefix, last_ip, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := repo.DB.Exec(query,
+		device.ID, device.UserID, device.Name, device.CookieHash, device.Fingerprint,
+		device.UserAgent, device.IPPrefix, device.LastIP, device.CreatedAt, device.LastSeenAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save device: %v", err)
+	}
+
+	return nil
+}
+
+// FindByCookie retrieves the active device of a user holding a device cookie.
+func (repo *MySQLDeviceRepository) FindByCookie(userID uuid.UUID, cookieHash string) (*Device, error) {
+	query := `SELECT ` + deviceColumns + ` FROM user_devices WHERE user_id = ? AND cookie_hash = ? AND revoked_at IS NULL`
+	return repo.findOne(query, userID, cookieHash)
+}
+
+// FindByFingerprint retrieves the most recent active device of a user with a fingerprint.
+func (repo *MySQLDeviceRepository) FindByFingerprint(userID uuid.UUID, fingerprint string) (*Device, error) {
+	query := `
+		SELECT ` + deviceColumns + ` FROM user_devices
+		WHERE user_id = ? AND fingerprint = ? AND revoked_at IS NULL
+		ORDER BY last_seen_at DESC
+		LIMIT 1
+	`
+	return repo.findOne(query, userID, fingerprint)
+}
+
+// FindAllByUser lists the devices of a user, most recently seen first.
+func (repo *MySQLDeviceRepository) FindAllByUser(userID uuid.UUID) ([]*Device, error) {
+	query := `SELECT ` + deviceColumns + ` FROM user_devices WHERE user_id = ? ORDER BY last_seen_at DESC`
+
+	rows, err := repo.DB.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %v", err)
+	}
+	defer rows.Close()
+
+	devices := []*Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %v", err)
+		}
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while reading rows: %v", err)
+	}
+
+	return devices, nil
+}
+
+// CountAll counts the devices of a user, revoked ones included.
+func (repo *MySQLDeviceRepository) CountAll(userID uuid.UUID) (int, error) {
+	var count int
+	err := repo.DB.QueryRow(`SELECT COUNT(*) FROM user_devices WHERE user_id = ?`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count devices: %v", err)
+	}
+	return count, nil
+}
+
+// Touch records a login from a known device, which may have a new cookie or network.
+func (repo *MySQLDeviceRepository) Touch(id uuid.UUID, cookieHash, fingerprint, ipPrefix, ip string, at time.Time) error {
+	query := `
+		UPDATE user_devices
+		SET cookie_hash = ?, fingerprint = ?, ip_prefix = ?, last_ip = ?, last_seen_at = ?
+		WHERE id = ?
+	`
+
+	if _, err := repo.DB.Exec(query, cookieHash, fingerprint, ipPrefix, ip, at, id); err != nil {
+		return fmt.Errorf("failed to update device: %v", err)
+	}
+	return nil
+}
+
+// Rename names a device, scoped to its owner.
+func (repo *MySQLDeviceRepository) Rename(userID, id uuid.UUID, name string) (bool, error) {
+	result, err := repo.DB.Exec(`UPDATE user_devices SET name = ? WHERE id = ? AND user_id = ?`, name, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to rename device: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to rename device: %v", err)
+	}
+
+	// MySQL reports 0 rows when the name did not change
+	if affected == 0 {
+		var exists bool
+		err := repo.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_devices WHERE id = ? AND user_id = ?)`, id, userID).Scan(&exists)
+		if err != nil {
+			return false, fmt.Errorf("failed to rename device: %v", err)
+		}
+		return exists, nil
+	}
+
+	return true, nil
+}
+
+// Revoke forgets a device, scoped to its owner so users cannot revoke each other's devices.
+func (repo *MySQLDeviceRepository) Revoke(userID, id uuid.UUID, at time.Time) (bool, error) {
+	query := `
+		UPDATE user_devices
+		SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+
+	result, err := repo.DB.Exec(query, at, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke device: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke device: %v", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (repo *MySQLDeviceRepository) findOne(query string, args ...interface{}) (*Device, error) {
+	device, err := scanDevice(repo.DB.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no device is found
+		}
+		return nil, fmt.Errorf("failed to find device: %v", err)
+	}
+	return device, nil
+}
+
+func scanDevice(row rowScanner) (*Device, error) {
+	var device Device
+	var revokedAt sql.NullTime
+
+	err := row.Scan(
+		&device.ID, &device.UserID, &device.Name, &device.CookieHash, &device.Fingerprint, &device.UserAgent,
+		&device.IPPrefix, &device.LastIP, &device.CreatedAt, &device.LastSeenAt, &revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		device.RevokedAt = &revokedAt.Time
+	}
+
+	return &device, nil
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shade_web_server/core/users"
+
+	"github.com/google/uuid"
+)
+
+// DeviceCookieMaxAge keeps the device cookie for as long as browsers allow
+const DeviceCookieMaxAge = 400 * 24 * time.Hour
+
+// maxDeviceCookieLength bounds the cookie values accepted from clients
+const maxDeviceCookieLength = 128
+
+var (
+	ErrDeviceMissing     = errors.New("device not found")
+	ErrInvalidDeviceName = errors.New("device name must be between 1 and 100 characters")
+)
+
+// NewDeviceNotifier is called when a user logs in from a device never seen
+// before, during the login request.
+type NewDeviceNotifier func(user *users.User, device *Device)
+
+// DeviceLogin is the outcome of recognizing the device of a login
+type DeviceLogin struct {
+	Device *Device
+	Cookie string // Value to store in the device cookie
+	New    bool   // The user never logged in from this device
+}
+
+// DeviceService remembers the devices users log in from, and the sessions
+// started on them.
+type DeviceService struct {
+	DeviceRepo  DeviceRepository
+	RefreshRepo RefreshTokenRepository
+	notifier    NewDeviceNotifier
+}
+
+// NewDeviceService initializes DeviceService.
+func NewDeviceService(repo DeviceRepository, refreshTokens RefreshTokenRepository) *DeviceService {
+	return &DeviceService{DeviceRepo: repo, RefreshRepo: refreshTokens}
+}
+
+// SetNotifier registers the function told about logins from new devices
+func (s *DeviceService) SetNotifier(notifier NewDeviceNotifier) {
+	s.notifier = notifier
+}
+
+// Recognize finds the device of a login by its cookie, then by its user
+// agent and network for browsers that lost the cookie, and registers it
+// when both fail. The first device of a user is trusted on first use, so
+// it is not reported as new. Revoked devices still count: forgetting every
+// device does not make the next one trusted again.
+func (s *DeviceService) Recognize(user *users.User, cookie, userAgent, ip string) (*DeviceLogin, error) {
+	// The cookie identifies the browser, users sharing it keep the same value
+	if cookie == "" || len(cookie) > maxDeviceCookieLength {
+		generated, err := generateOpaqueToken()
+		if err != nil {
+			return nil, err
+		}
+		cookie = generated
+	}
+
+	userAgent = truncate(userAgent, 512)
+
+	cookieHash := hashToken(cookie)
+	prefix := ipPrefix(ip)
+	fingerprint := deviceFingerprint(userAgent, prefix)
+	now := time.Now()
+
+	device, err := s.DeviceRepo.FindByCookie(user.ID, cookieHash)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		device, err = s.DeviceRepo.FindByFingerprint(user.ID, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if device != nil {
+		if err := s.DeviceRepo.Touch(device.ID, cookieHash, fingerprint, prefix, ip, now); err != nil {
+			return nil, err
+		}
+		device.CookieHash = cookieHash
+		device.Fingerprint = fingerprint
+		device.IPPrefix = prefix
+		device.LastIP = ip
+		device.LastSeenAt = now
+		return &DeviceLogin{Device: device, Cookie: cookie}, nil
+	}
+
+	known, err := s.DeviceRepo.CountAll(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	device = &Device{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		Name:        defaultDeviceName(userAgent),
+		CookieHash:  cookieHash,
+		Fingerprint: fingerprint,
+		UserAgent:   userAgent,
+		IPPrefix:    prefix,
+		LastIP:      ip,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+	}
+	if err := s.DeviceRepo.Save(device); err != nil {
+		return nil, err
+	}
+
+	login := &DeviceLogin{Device: device, Cookie: cookie, New: known > 0}
+	if login.New && s.notifier != nil {
+		s.notifier(user, device)
+	}
+
+	return login, nil
+}
+
+// List returns the devices of a user, marking the one holding cookie.
+func (s *DeviceService) List(userID uuid.UUID, cookie string) ([]*Device, error) {
+	devices, err := s.DeviceRepo.FindAllByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cookie != "" {
+		cookieHash := hashToken(cookie)
+		for _, device := range devices {
+			device.Current = device.RevokedAt == nil && device.CookieHash == cookieHash
+		}
+	}
+
+	return devices, nil
+}
+
+// Rename names a device of the user.
+func (s *DeviceService) Rename(userID, deviceID uuid.UUID, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		return ErrInvalidDeviceName
+	}
+
+	found, err := s.DeviceRepo.Rename(userID, deviceID, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrDeviceMissing
+	}
+	return nil
+}
+
+// AttachSession records that the tokens of a login were issued to a device,
+// so revoking the device ends the session.
+func (s *DeviceService) AttachSession(deviceID uuid.UUID, tokens *TokenPair) error {
+	return s.RefreshRepo.AttachDevice(tokens.FamilyID, deviceID)
+}
+
+// Revoke forgets a device of the user and ends the sessions started on it:
+// their refresh tokens stop working at once, and the last access token
+// expires within AccessTokenTTL. Its next login counts as a new device.
+func (s *DeviceService) Revoke(userID, deviceID uuid.UUID) error {
+	now := time.Now()
+
+	// Sessions first, so a failure can be retried while the device is listed
+	if err := s.RefreshRepo.RevokeDevice(userID, deviceID, now); err != nil {
+		return err
+	}
+
+	revoked, err := s.DeviceRepo.Revoke(userID, deviceID, now)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrDeviceMissing
+	}
+	return nil
+}
+
+// ipPrefix returns the network of an IP: the /24 of IPv4 addresses and the
+// /48 of IPv6 ones, so a device keeps its fingerprint when its ISP
+// reassigns the address.
+func ipPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return fmt.Sprintf("%s/24", v4.Mask(net.CIDRMask(24, 32)))
+	}
+	return fmt.Sprintf("%s/48", parsed.Mask(net.CIDRMask(48, 128)))
+}
+
+func deviceFingerprint(userAgent, prefix string) string {
+	sum := sha256.Sum256([]byte(userAgent + "\n" + prefix))
+	return hex.EncodeToString(sum[:])
+}
+
+// defaultDeviceName describes a device from its user agent until the user
+// names it
+func defaultDeviceName(userAgent string) string {
+	browser := "Unknown browser"
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	for _, candidate := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			return browser + " on " + candidate.name
+		}
+	}
+
+	if browser == "Unknown browser" && userAgent != "" {
+		return truncate(userAgent, 100)
+	}
+	return browser
+}
+
+// truncate cuts s to max characters, the length of its column, without
+// splitting a multi-byte character. Invalid UTF-8 is replaced, MySQL would
+// reject it.
+func truncate(s string, max int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
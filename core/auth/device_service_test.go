@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestDeviceServiceRevokeEndsSessions(t *testing.T) {
+	userID, deviceID := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name        string
+		deviceFound bool
+		wantErr     error
+	}{
+		{name: "device of the user", deviceFound: true},
+		{name: "unknown device", deviceFound: false, wantErr: ErrDeviceMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			// Only the sessions of the user on that device are revoked
+			mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at = \?\s+WHERE user_id = \? AND device_id = \? AND revoked_at IS NULL`).
+				WithArgs(sqlmock.AnyArg(), userID, deviceID).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+
+			affected := int64(0)
+			if tt.deviceFound {
+				affected = 1
+			}
+			mock.ExpectExec(`UPDATE user_devices`).
+				WithArgs(sqlmock.AnyArg(), deviceID, userID).
+				WillReturnResult(sqlmock.NewResult(0, affected))
+
+			service := NewDeviceService(NewMySQLDeviceRepository(db), NewMySQLRefreshTokenRepository(db))
+			if err := service.Revoke(userID, deviceID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Revoke error = %v, want %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDeviceServiceAttachSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	familyID, deviceID := uuid.New(), uuid.New()
+	mock.ExpectExec(`UPDATE refresh_tokens\s+SET device_id = \?\s+WHERE family_id = \?`).
+		WithArgs(deviceID, familyID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	service := NewDeviceService(NewMySQLDeviceRepository(db), NewMySQLRefreshTokenRepository(db))
+	if err := service.AttachSession(deviceID, &TokenPair{FamilyID: familyID}); err != nil {
+		t.Fatalf("AttachSession: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
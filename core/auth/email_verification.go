@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailVerificationToken is a single-use token emailed to confirm an address.
+type EmailVerificationToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Email     string // Address the link was sent to
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type VerifyEmail struct {
+	Token string `json:"token"`
+}
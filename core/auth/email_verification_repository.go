@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailVerificationRepository defines methods for persisting email verification tokens.
+type EmailVerificationRepository interface {
+	Save(token *EmailVerificationToken) error                         // Store a new token
+	FindByHash(hash string) (*EmailVerificationToken, error)          // Retrieve a token by its hash, nil if missing
+	MarkUsed(id uuid.UUID, at time.Time) (bool, error)                // Consume a token, false if it was already used
+	InvalidateForUser(userID uuid.UUID, at time.Time) error           // Consume every pending token of a user
+	SentSince(userID uuid.UUID, since time.Time) ([]time.Time, error) // Creation times of recent tokens, newest first
+}
+
+// MySQLEmailVerificationRepository is the implementation of EmailVerificationRepository using MySQL.
+type MySQLEmailVerificationRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLEmailVerificationRepository creates a new MySQLEmailVerificationRepository.
+func NewMySQLEmailVerificationRepository(db *sql.DB) *MySQLEmailVerificationRepository {
+	return &MySQLEmailVerificationRepository{DB: db}
+}
+
+// Save stores an email verification token.
+func (repo *MySQLEmailVerificationRepository) Save(token *EmailVerificationToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO email_verification_tokens (id, user_id, email, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := repo.DB.Exec(query, token.ID, token.UserID, token.Email, token.TokenHash, token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save email verification token: %v", err)
+	}
+
+	return nil
+}
+
+// FindByHash retrieves an email verification token by the hash of its value.
+func (repo *MySQLEmailVerificationRepository) FindByHash(hash string) (*EmailVerificationToken, error) {
+	query := `
+		SELECT id, user_id, email, token_hash, expires_at, used_at, created_at
+		FROM email_verification_tokens
+		WHERE token_hash = ?
+	`
+
+	var token EmailVerificationToken
+	var email sql.NullString
+	var usedAt sql.NullTime
+	err := repo.DB.QueryRow(query, hash).Scan(&token.ID, &token.UserID, &email, &token.TokenHash, &token.ExpiresAt, &usedAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no token is found
+		}
+		return nil, fmt.Errorf("failed to find email verification token: %v", err)
+	}
+
+	token.Email = email.String // Empty for links sent before addresses were recorded
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	return &token, nil
+}
+
+// MarkUsed consumes a token, the conditional update guarantees a single use.
+func (repo *MySQLEmailVerificationRepository) MarkUsed(id uuid.UUID, at time.Time) (bool, error) {
+	result, err := repo.DB.Exec(`UPDATE email_verification_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL`, at, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to use email verification token: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use email verification token: %v", err)
+	}
+
+	return affected == 1, nil
+}
+
+// InvalidateForUser consumes every pending token of a user.
+func (repo *MySQLEmailVerificationRepository) InvalidateForUser(userID uuid.UUID, at time.Time) error {
+	_, err := repo.DB.Exec(`UPDATE email_verification_tokens SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, at, userID)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate email verification tokens: %v", err)
+	}
+
+	return nil
+}
+
+// SentSince returns when tokens were sent to a user after the given time, newest first.
+func (repo *MySQLEmailVerificationRepository) SentSince(userID uuid.UUID, since time.Time) ([]time.Time, error) {
+	query := `
+		SELECT created_at
+		FROM email_verification_tokens
+		WHERE user_id = ? AND created_at >= ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := repo.DB.Query(query, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch email verification tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var sent []time.Time
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan email verification token: %v", err)
+		}
+		sent = append(sent, createdAt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while reading rows: %v", err)
+	}
+
+	return sent, nil
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"shade_web_server/core/mail"
+	"shade_web_server/core/users"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EmailVerificationTTL      = 48 * time.Hour
+	VerificationEmailCooldown = time.Minute // Minimum time between two verification emails
+	VerificationEmailsPerHour = 5
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+)
+
+// VerificationRateLimitError is returned when a user asks for too many verification emails.
+type VerificationRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *VerificationRateLimitError) Error() string {
+	return fmt.Sprintf("too many verification emails, retry in %d seconds", int(e.RetryAfter.Seconds())+1)
+}
+
+// EmailVerificationService confirms that users own the email they signed up with.
+type EmailVerificationService struct {
+	VerifyRepo  EmailVerificationRepository
+	UserService *users.UserService
+	Mailer      mail.Mailer
+	BaseURL     string // Frontend URL the verification link points to
+}
+
+// NewEmailVerificationService initializes EmailVerificationService.
+func NewEmailVerificationService(repo EmailVerificationRepository, userService *users.UserService, mailer mail.Mailer, baseURL string) *EmailVerificationService {
+	return &EmailVerificationService{
+		VerifyRepo:  repo,
+		UserService: userService,
+		Mailer:      mailer,
+		BaseURL:     baseURL,
+	}
+}
+
+// SendVerification emails a new verification link to the user, unless too
+// many were sent recently. Previous links stop working.
+func (s *EmailVerificationService) SendVerification(user *users.User) error {
+	if user.EmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+
+	now := time.Now()
+
+	sent, err := s.VerifyRepo.SentSince(user.ID, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if len(sent) > 0 && now.Sub(sent[0]) < VerificationEmailCooldown {
+		return &VerificationRateLimitError{RetryAfter: VerificationEmailCooldown - now.Sub(sent[0])}
+	}
+	if len(sent) >= VerificationEmailsPerHour {
+		oldest := sent[len(sent)-1]
+		return &VerificationRateLimitError{RetryAfter: time.Hour - now.Sub(oldest)}
+	}
+
+	if err := s.VerifyRepo.InvalidateForUser(user.ID, now); err != nil {
+		return err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.VerifyRepo.Save(&EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(EmailVerificationTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := s.BaseURL + "/verify-email?token=" + url.QueryEscape(token)
+
+	return s.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your Shade email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address to start deploying containers on Shade:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not sign up, you can ignore this email.\n",
+			user.Name, link, int(EmailVerificationTTL.Hours())),
+	})
+}
+
+// EmailChanged stops the links sent to the previous address of a user. It
+// runs before SendVerification, which may be rate limited.
+func (s *EmailVerificationService) EmailChanged(userID uuid.UUID) error {
+	return s.VerifyRepo.InvalidateForUser(userID, time.Now())
+}
+
+// Resend sends a new verification email to a user identified by ID.
+func (s *EmailVerificationService) Resend(userID string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	user, err := s.UserService.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	return s.SendVerification(user)
+}
+
+// Verify consumes a verification token and marks the email as verified.
+func (s *EmailVerificationService) Verify(token string) (uuid.UUID, error) {
+	stored, err := s.VerifyRepo.FindByHash(hashToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if stored == nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return uuid.Nil, ErrInvalidVerificationToken
+	}
+
+	// The link only proves ownership of the address it was sent to
+	user, err := s.UserService.GetUserByID(stored.UserID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if stored.Email == "" || !strings.EqualFold(stored.Email, user.Email) {
+		return uuid.Nil, ErrInvalidVerificationToken
+	}
+
+	used, err := s.VerifyRepo.MarkUsed(stored.ID, time.Now())
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !used {
+		return uuid.Nil, ErrInvalidVerificationToken
+	}
+
+	if err := s.UserService.MarkEmailVerified(stored.UserID); err != nil {
+		return uuid.Nil, err
+	}
+
+	return stored.UserID, nil
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shade_web_server/core/users"
+
+	"github.com/google/uuid"
+)
+
+// newTestKeys signs tokens with a throwaway HMAC key
+func newTestKeys(t *testing.T) *KeyProvider {
+	t.Helper()
+	keys, err := NewKeyProvider([]SigningKey{{ID: "test", Secret: strings.Repeat("s", minSecretLength)}}, "test", time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyProvider: %v", err)
+	}
+	return keys
+}
+
+// memoryUsers implements the parts of users.UserRepository the auth flows
+// use, the other methods panic
+type memoryUsers struct {
+	users.UserRepository
+	lock  sync.Mutex
+	users map[uuid.UUID]*users.User
+}
+
+func newMemoryUsers(existing ...*users.User) *memoryUsers {
+	repo := &memoryUsers{users: make(map[uuid.UUID]*users.User)}
+	for _, user := range existing {
+		repo.users[user.ID] = user
+	}
+	return repo
+}
+
+func (r *memoryUsers) Save(user *users.User) (*users.User, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return nil, users.ErrEmailTaken
+		}
+	}
+	saved := *user
+	r.users[user.ID] = &saved
+	return user, nil
+}
+
+func (r *memoryUsers) FindByID(id uuid.UUID) (*users.User, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if user, ok := r.users[id]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) FindByEmail(email string) (*users.User, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, user := range r.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) MarkEmailVerified(id uuid.UUID, at time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.users[id].EmailVerifiedAt = &at
+	return nil
+}
+
+// memoryRefreshTokens is an in-memory RefreshTokenRepository. loseRace
+// makes MarkRotated fail as if a concurrent refresh used the token first.
+type memoryRefreshTokens struct {
+	lock     sync.Mutex
+	tokens   map[uuid.UUID]*RefreshToken
+	loseRace bool
+}
+
+func newMemoryRefreshTokens() *memoryRefreshTokens {
+	return &memoryRefreshTokens{tokens: make(map[uuid.UUID]*RefreshToken)}
+}
+
+func (r *memoryRefreshTokens) Save(token *RefreshToken) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	saved := *token
+	saved.CreatedAt = time.Now()
+	r.tokens[token.ID] = &saved
+	return nil
+}
+
+func (r *memoryRefreshTokens) FindByHash(hash string) (*RefreshToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, token := range r.tokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryRefreshTokens) MarkRotated(id uuid.UUID, at time.Time) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	token := r.tokens[id]
+	if r.loseRace || token.RotatedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+	token.RotatedAt = &at
+	return true, nil
+}
+
+func (r *memoryRefreshTokens) revokeWhere(at time.Time, match func(*RefreshToken) bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, token := range r.tokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &at
+		}
+	}
+}
+
+func (r *memoryRefreshTokens) RevokeFamily(familyID uuid.UUID, at time.Time) error {
+	r.revokeWhere(at, func(token *RefreshToken) bool { return token.FamilyID == familyID })
+	return nil
+}
+
+func (r *memoryRefreshTokens) RevokeAllForUser(userID uuid.UUID, at time.Time) error {
+	r.revokeWhere(at, func(token *RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (r *memoryRefreshTokens) AttachDevice(familyID, deviceID uuid.UUID) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, token := range r.tokens {
+		if token.FamilyID == familyID {
+			token.DeviceID = deviceID
+		}
+	}
+	return nil
+}
+
+func (r *memoryRefreshTokens) RevokeDevice(userID, deviceID uuid.UUID, at time.Time) error {
+	r.revokeWhere(at, func(token *RefreshToken) bool { return token.UserID == userID && token.DeviceID == deviceID })
+	return nil
+}
@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultKeyID          = "default"
+	DefaultKeyGracePeriod = time.Hour // How long tokens signed with a retired key stay valid
+	minSecretLength       = 32
+	minRSAKeyBits         = 2048
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256" // Shared HMAC secret, only Shade itself can verify
+	AlgRS256 = "RS256" // RSA key pair, public key published in the JWKS
+	AlgEdDSA = "EdDSA" // Ed25519 key pair, public key published in the JWKS
+)
+
+// SigningKey is a single key identified by its kid. HMAC keys use Secret,
+// asymmetric keys a PEM encoded private key (or only the public key for keys
+// that are kept around to verify tokens).
+type SigningKey struct {
+	ID             string     `json:"kid"`
+	Algorithm      string     `json:"alg,omitempty"` // Defaults to HS256
+	Secret         string     `json:"secret,omitempty"`
+	PrivateKey     string     `json:"private_key,omitempty"`
+	PrivateKeyFile string     `json:"private_key_file,omitempty"`
+	PublicKey      string     `json:"public_key,omitempty"`
+	PublicKeyFile  string     `json:"public_key_file,omitempty"`
+	RetiredAt      *time.Time `json:"retired_at,omitempty"` // Key no longer signs, only verifies until the grace period ends
+
+	method    jwt.SigningMethod
+	signKey   interface{} // nil for verification-only keys
+	verifyKey interface{}
+}
+
+// KeyFile is the format of the file referenced by JWT_KEYS_FILE.
+type KeyFile struct {
+	ActiveKeyID string       `json:"active_kid"`
+	GracePeriod string       `json:"grace_period,omitempty"` // Go duration, e.g. "1h"
+	Keys        []SigningKey `json:"keys"`
+}
+
+// JWK is the public part of a signing key as published in the JWKS.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyProvider holds the keys used to sign and verify JWTs. New tokens are
+// signed with the active key and carry its kid in the header, so older keys
+// can keep verifying live sessions while a rotation is in progress.
+type KeyProvider struct {
+	keys        map[string]SigningKey
+	order       []string // kids in configuration order, keeps the JWKS stable
+	activeID    string
+	gracePeriod time.Duration
+}
+
+// NewKeyProvider validates the keys and creates a KeyProvider.
+func NewKeyProvider(keys []SigningKey, activeID string, gracePeriod time.Duration) (*KeyProvider, error) {
+	provider := &KeyProvider{
+		keys:        make(map[string]SigningKey),
+		activeID:    activeID,
+		gracePeriod: gracePeriod,
+	}
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("signing key without kid")
+		}
+		if _, exists := provider.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key %q", key.ID)
+		}
+		if err := key.load(); err != nil {
+			return nil, fmt.Errorf("signing key %q: %v", key.ID, err)
+		}
+		provider.keys[key.ID] = key
+		provider.order = append(provider.order, key.ID)
+	}
+
+	active, ok := provider.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found", activeID)
+	}
+	if active.RetiredAt != nil {
+		return nil, fmt.Errorf("active signing key %q is retired", activeID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeID)
+	}
+
+	return provider, nil
+}
+
+// LoadKeyProvider builds a KeyProvider from the environment. JWT_KEYS_FILE
+// points to a KeyFile with several keys. Otherwise a single key is configured
+// with JWT_SIGNING_ALG (HS256 by default) and either JWT_SECRET or
+// JWT_PRIVATE_KEY_FILE, optionally named by JWT_KEY_ID.
+// JWT_KEY_GRACE_PERIOD overrides the grace period of retired keys.
+func LoadKeyProvider() (*KeyProvider, error) {
+	gracePeriod := DefaultKeyGracePeriod
+	var keys []SigningKey
+	var activeID string
+
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+
+		var file KeyFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse key file: %v", err)
+		}
+
+		if file.GracePeriod != "" {
+			gracePeriod, err = time.ParseDuration(file.GracePeriod)
+			if err != nil {
+				return nil, fmt.Errorf("invalid grace_period in key file: %v", err)
+			}
+		}
+
+		keys = file.Keys
+		activeID = file.ActiveKeyID
+	} else {
+		activeID = os.Getenv("JWT_KEY_ID")
+		if activeID == "" {
+			activeID = DefaultKeyID
+		}
+
+		key := SigningKey{
+			ID:             activeID,
+			Algorithm:      os.Getenv("JWT_SIGNING_ALG"),
+			Secret:         os.Getenv("JWT_SECRET"),
+			PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		}
+		if key.Secret == "" && key.PrivateKeyFile == "" {
+			return nil, errors.New("no JWT signing key configured, set JWT_SECRET, JWT_PRIVATE_KEY_FILE or JWT_KEYS_FILE")
+		}
+		keys = []SigningKey{key}
+	}
+
+	if value := os.Getenv("JWT_KEY_GRACE_PERIOD"); value != "" {
+		var err error
+		gracePeriod, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEY_GRACE_PERIOD: %v", err)
+		}
+	}
+
+	return NewKeyProvider(keys, activeID, gracePeriod)
+}
+
+// Sign signs the claims with the active key.
+func (p *KeyProvider) Sign(claims jwt.Claims) (string, error) {
+	key := p.keys[p.activeID]
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc selects the verification key from the token's kid header. It is
+// meant to be passed to jwt.Parse.
+func (p *KeyProvider) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("missing kid header")
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The algorithm is bound to the key, never taken from the token alone
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	if key.expired(p.gracePeriod) {
+		return nil, fmt.Errorf("signing key %q is retired", kid)
+	}
+
+	return key.verifyKey, nil
+}
+
+// Parse verifies a token string and returns its claims.
+func (p *KeyProvider) Parse(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, p.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys that other services can verify tokens with.
+// HMAC keys are never published and retired keys disappear once their grace
+// period is over.
+func (p *KeyProvider) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, kid := range p.order {
+		key := p.keys[kid]
+		if key.expired(p.gracePeriod) {
+			continue
+		}
+
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: AlgRS256,
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: AlgEdDSA,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return set
+}
+
+// expired reports whether a retired key is past its grace period.
+func (k *SigningKey) expired(gracePeriod time.Duration) bool {
+	return k.RetiredAt != nil && time.Now().After(k.RetiredAt.Add(gracePeriod))
+}
+
+// load parses the key material according to the key's algorithm.
+func (k *SigningKey) load() error {
+	if k.Algorithm == "" {
+		k.Algorithm = AlgHS256
+	}
+
+	switch k.Algorithm {
+	case AlgHS256:
+		if len(k.Secret) < minSecretLength {
+			return fmt.Errorf("secret must be at least %d characters", minSecretLength)
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(k.Secret)
+		k.verifyKey = []byte(k.Secret)
+		return nil
+	case AlgRS256:
+		k.method = jwt.SigningMethodRS256
+	case AlgEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("unsupported algorithm %q", k.Algorithm)
+	}
+
+	privatePEM, err := readPEM(k.PrivateKey, k.PrivateKeyFile)
+	if err != nil {
+		return err
+	}
+
+	if privatePEM != nil {
+		signer, err := parsePrivateKey(privatePEM)
+		if err != nil {
+			return err
+		}
+		k.signKey = signer
+		k.verifyKey = signer.Public()
+	} else {
+		publicPEM, err := readPEM(k.PublicKey, k.PublicKeyFile)
+		if err != nil {
+			return err
+		}
+		if publicPEM == nil {
+			return errors.New("private or public key required")
+		}
+		k.verifyKey, err = x509.ParsePKIXPublicKey(publicPEM.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid public key: %v", err)
+		}
+	}
+
+	// Make sure the key type matches the algorithm it is declared for
+	switch public := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		if k.Algorithm != AlgRS256 {
+			return fmt.Errorf("RSA key cannot be used with %s", k.Algorithm)
+		}
+		if public.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+		}
+	case ed25519.PublicKey:
+		if k.Algorithm != AlgEdDSA {
+			return fmt.Errorf("Ed25519 key cannot be used with %s", k.Algorithm)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", public)
+	}
+
+	return nil
+}
+
+// readPEM decodes an inline PEM block or the one stored in a file, nil if neither is set.
+func readPEM(inline, path string) (*pem.Block, error) {
+	data := []byte(inline)
+	if inline == "" {
+		if path == "" {
+			return nil, nil
+		}
+
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	return block, nil
+}
+
+// parsePrivateKey accepts PKCS#8 keys and PKCS#1 RSA keys.
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,29 @@
+package auth
+
+import "time"
+
+// MFASettings holds the TOTP enrollment of a user.
+type MFASettings struct {
+	UserID       string
+	Secret       string
+	EnabledAt    *time.Time // nil until the user confirmed a first code
+	LastUsedStep int64      // Last accepted TOTP time step
+}
+
+// Enabled reports whether the enrollment was confirmed.
+func (m *MFASettings) Enabled() bool {
+	return m != nil && m.EnabledAt != nil
+}
+
+// MFAEnrollment is returned when a user starts enrolling an authenticator app.
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"otpauth_uri"` // Rendered as a QR code by the client
+}
+
+// MFAChallenge is returned by /auth/login/ instead of tokens when the user has 2FA enabled.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFARepository defines methods for persisting TOTP enrollments and recovery codes.
+type MFARepository interface {
+	FindByUserID(userID string) (*MFASettings, error)                // Retrieve the enrollment, nil if none
+	SavePending(userID, secret string) error                         // Store a new, unconfirmed secret
+	Enable(userID string, at time.Time, step int64) error            // Confirm the enrollment
+	UseStep(userID string, step int64) (bool, error)                 // Record an accepted step, false if replayed
+	Delete(userID string) error                                      // Remove the enrollment and its recovery codes
+	ReplaceRecoveryCodes(userID string, hashes []string) error       // Store a fresh set of recovery codes
+	UseRecoveryCode(userID, hash string, at time.Time) (bool, error) // Consume a recovery code, false if unknown or used
+}
+
+// MySQLMFARepository is the implementation of MFARepository using MySQL.
+type MySQLMFARepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLMFARepository creates a new MySQLMFARepository.
+func NewMySQLMFARepository(db *sql.DB) *MySQLMFARepository {
+	return &MySQLMFARepository{DB: db}
+}
+
+// FindByUserID retrieves the TOTP enrollment of a user.
+func (repo *MySQLMFARepository) FindByUserID(userID string) (*MFASettings, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_used_step
+		FROM mfa_settings
+		WHERE user_id = ?
+	`
+
+	var settings MFASettings
+	var enabledAt sql.NullTime
+	err := repo.DB.QueryRow(query, userID).Scan(&settings.UserID, &settings.Secret, &enabledAt, &settings.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if the user never enrolled
+		}
+		return nil, fmt.Errorf("failed to find mfa settings: %v", err)
+	}
+
+	if enabledAt.Valid {
+		settings.EnabledAt = &enabledAt.Time
+	}
+
+	return &settings, nil
+}
+
+// SavePending stores a new secret, replacing a previous unconfirmed one.
+func (repo *MySQLMFARepository) SavePending(userID, secret string) error {
+	query := `
+		INSERT INTO mfa_settings (user_id, secret, enabled_at, last_used_step)
+		VALUES (?, ?, NULL, 0)
+		ON DUPLICATE KEY UPDATE
+			secret = VALUES(secret),
+			enabled_at = NULL,
+			last_used_step = 0
+	`
+
+	if _, err := repo.DB.Exec(query, userID, secret); err != nil {
+		return fmt.Errorf("failed to save mfa secret: %v", err)
+	}
+
+	return nil
+}
+
+// Enable confirms the enrollment of a user.
+func (repo *MySQLMFARepository) Enable(userID string, at time.Time, step int64) error {
+	query := `
+		UPDATE mfa_settings
+		SET enabled_at = ?, last_used_step = ?
+		WHERE user_id = ?
+	`
+
+	if _, err := repo.DB.Exec(query, at, step, userID); err != nil {
+		return fmt.Errorf("failed to enable mfa: %v", err)
+	}
+
+	return nil
+}
+
+// UseStep records an accepted time step. The conditional update rejects a
+// code that was already used, even by a concurrent request.
+func (repo *MySQLMFARepository) UseStep(userID string, step int64) (bool, error) {
+	query := `
+		UPDATE mfa_settings
+		SET last_used_step = ?
+		WHERE user_id = ? AND last_used_step < ?
+	`
+
+	result, err := repo.DB.Exec(query, step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record mfa step: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record mfa step: %v", err)
+	}
+
+	return affected == 1, nil
+}
+
+// Delete removes the enrollment and the recovery codes of a user.
+func (repo *MySQLMFARepository) Delete(userID string) error {
+	if _, err := repo.DB.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	if _, err := repo.DB.Exec(`DELETE FROM mfa_settings WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete mfa settings: %v", err)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes drops the previous recovery codes and stores new ones.
+func (repo *MySQLMFARepository) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	for _, hash := range hashes {
+		_, err := tx.Exec(
+			`INSERT INTO mfa_recovery_codes (id, user_id, code_hash) VALUES (?, ?, ?)`,
+			uuid.New(), userID, hash,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save recovery code: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save recovery codes: %v", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code.
+func (repo *MySQLMFARepository) UseRecoveryCode(userID, hash string, at time.Time) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+		LIMIT 1
+	`
+
+	result, err := repo.DB.Exec(query, at, userID, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	return affected == 1, nil
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shade_web_server/core/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	MFAChallengeTTL   = 5 * time.Minute // Time the user has to enter the TOTP code after the password
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+	ErrInvalidMFAToken   = errors.New("invalid or expired mfa token")
+)
+
+// MFAService handles TOTP enrollment and the second step of the login.
+type MFAService struct {
+	MFARepo MFARepository
+	Auth    *AuthService
+	Issuer  string // Shown in the authenticator app
+}
+
+// MFAChallengeClaims identifies the login waiting for a second factor.
+type MFAChallengeClaims struct {
+	UserID    string
+	ID        string
+	ExpiresAt time.Time
+}
+
+// NewMFAService initializes MFAService.
+func NewMFAService(repo MFARepository, authService *AuthService, issuer string) *MFAService {
+	return &MFAService{
+		MFARepo: repo,
+		Auth:    authService,
+		Issuer:  issuer,
+	}
+}
+
+// IsEnabled reports whether the user confirmed a TOTP enrollment.
+func (s *MFAService) IsEnabled(userID string) (bool, error) {
+	settings, err := s.MFARepo.FindByUserID(userID)
+	if err != nil {
+		return false, err
+	}
+	return settings.Enabled(), nil
+}
+
+// BeginEnrollment generates a new secret for the user. The enrollment only
+// takes effect once a code generated from it is confirmed.
+func (s *MFAService) BeginEnrollment(user *users.User) (*MFAEnrollment, error) {
+	enabled, err := s.IsEnabled(user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.MFARepo.SavePending(user.ID.String(), secret); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(s.Issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmEnrollment enables 2FA when the code matches the pending secret and
+// returns the recovery codes. They are only ever shown at this point.
+func (s *MFAService) ConfirmEnrollment(userID, code string) ([]string, error) {
+	settings, err := s.MFARepo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, ErrMFANotEnabled
+	}
+	if settings.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	now := time.Now()
+	step, ok := ValidateTOTP(settings.Secret, code, now)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.MFARepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	if err := s.MFARepo.Enable(userID, now, step); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify checks a TOTP code, or a recovery code when no TOTP code is given.
+// Each code is accepted only once.
+func (s *MFAService) Verify(userID, code, recoveryCode string) error {
+	settings, err := s.MFARepo.FindByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if !settings.Enabled() {
+		return ErrMFANotEnabled
+	}
+
+	now := time.Now()
+
+	if code == "" && recoveryCode != "" {
+		used, err := s.MFARepo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)), now)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	step, ok := ValidateTOTP(settings.Secret, code, now)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	// Reject a code that was already used to log in
+	fresh, err := s.MFARepo.UseStep(userID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// Disable removes the enrollment after verifying a code.
+func (s *MFAService) Disable(userID, code, recoveryCode string) error {
+	if err := s.Verify(userID, code, recoveryCode); err != nil {
+		return err
+	}
+	return s.MFARepo.Delete(userID)
+}
+
+// CreateChallenge issues the short-lived token that proves the password step
+// succeeded. It cannot be used as an access token.
+func (s *MFAService) CreateChallenge(user *users.User) (*MFAChallenge, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":     uuid.New().String(),
+		"typ":     TokenTypeMFAChallenge,
+		"user_id": user.ID.String(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(MFAChallengeTTL).Unix(),
+	}
+
+	token, err := s.Auth.Keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// ParseChallenge verifies an MFA challenge token.
+func (s *MFAService) ParseChallenge(mfaToken string) (*MFAChallengeClaims, error) {
+	claims, err := s.Auth.Keys.Parse(mfaToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	if typ, _ := claims["typ"].(string); typ != TokenTypeMFAChallenge {
+		return nil, ErrInvalidMFAToken
+	}
+
+	userID, _ := claims["user_id"].(string)
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if userID == "" || jti == "" || err != nil || expiresAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	revoked, err := s.Auth.Revocations.IsTokenRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidMFAToken
+	}
+
+	return &MFAChallengeClaims{UserID: userID, ID: jti, ExpiresAt: expiresAt.Time}, nil
+}
+
+// CompleteChallenge consumes the challenge and issues the real tokens. It
+// must only be called once the second factor was verified.
+func (s *MFAService) CompleteChallenge(challenge *MFAChallengeClaims) (*users.User, *TokenPair, error) {
+	if err := s.Auth.Revocations.RevokeToken(challenge.ID, challenge.ExpiresAt); err != nil {
+		return nil, nil, err
+	}
+
+	id, err := uuid.Parse(challenge.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidMFAToken
+	}
+
+	user, err := s.Auth.UserService.GetUserByID(id)
+	if err != nil {
+		return nil, nil, ErrInvalidMFAToken
+	}
+	if !user.Active() {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	tokens, err := s.Auth.IssueTokens(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+}
+
+// generateRecoveryCodes returns the codes shown to the user and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+
+		code := string(b[:5]) + "-" + string(b[5:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces typed by the user.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryMFA is an in-memory MFARepository with the replay rule of the MySQL one
+type memoryMFA struct {
+	lock     sync.Mutex
+	settings map[string]*MFASettings
+}
+
+func newMemoryMFA() *memoryMFA {
+	return &memoryMFA{settings: make(map[string]*MFASettings)}
+}
+
+func (r *memoryMFA) FindByUserID(userID string) (*MFASettings, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if settings, ok := r.settings[userID]; ok {
+		copied := *settings
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (r *memoryMFA) SavePending(userID, secret string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.settings[userID] = &MFASettings{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (r *memoryMFA) Enable(userID string, at time.Time, step int64) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.settings[userID].EnabledAt = &at
+	r.settings[userID].LastUsedStep = step
+	return nil
+}
+
+func (r *memoryMFA) UseStep(userID string, step int64) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	settings := r.settings[userID]
+	if settings.LastUsedStep >= step {
+		return false, nil
+	}
+	settings.LastUsedStep = step
+	return true, nil
+}
+
+func (r *memoryMFA) Delete(userID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.settings, userID)
+	return nil
+}
+
+func (r *memoryMFA) ReplaceRecoveryCodes(userID string, hashes []string) error { return nil }
+
+func (r *memoryMFA) UseRecoveryCode(userID, hash string, at time.Time) (bool, error) {
+	return false, nil
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+
+	at := time.Unix(1700000000, 0)
+	current := at.Unix() / int64(totpPeriod.Seconds())
+
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{"current step", totpCode(key, current), true},
+		{"previous step", totpCode(key, current-1), true},
+		{"next step", totpCode(key, current+1), true},
+		{"two steps old", totpCode(key, current-2), false},
+		{"two steps ahead", totpCode(key, current+2), false},
+		{"too short", totpCode(key, current)[:5], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, tt.code, at)
+			if ok != tt.wantOK {
+				t.Fatalf("ValidateTOTP ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && totpCode(key, step) != tt.code {
+				t.Errorf("returned step %d does not match the code", step)
+			}
+		})
+	}
+}
+
+func TestMFAVerifyRejectsReplayedSteps(t *testing.T) {
+	// Steps are relative to the current one when the test starts. Crossing a
+	// step boundary during the test keeps the accepted codes within the skew.
+	tests := []struct {
+		name     string
+		attempts []int64 // Step offsets of the codes entered in order
+		want     []error
+	}{
+		{
+			name:     "a code works once",
+			attempts: []int64{0, 0},
+			want:     []error{nil, ErrInvalidMFACode},
+		},
+		{
+			name:     "a later step is accepted",
+			attempts: []int64{0, 1},
+			want:     []error{nil, nil},
+		},
+		{
+			name:     "an older step is rejected once a newer one was used",
+			attempts: []int64{1, 0},
+			want:     []error{nil, ErrInvalidMFACode},
+		},
+		{
+			name:     "the confirmation code cannot log in",
+			attempts: []int64{-1},
+			want:     []error{ErrInvalidMFACode},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := GenerateTOTPSecret()
+			if err != nil {
+				t.Fatalf("GenerateTOTPSecret: %v", err)
+			}
+			key, _ := totpEncoding.DecodeString(secret)
+			current := time.Now().Unix() / int64(totpPeriod.Seconds())
+
+			repo := newMemoryMFA()
+			repo.SavePending("user", secret)
+			// Enrollment was confirmed with the code of the previous step
+			repo.Enable("user", time.Now(), current-1)
+
+			service := NewMFAService(repo, nil, "Shade")
+			for i, offset := range tt.attempts {
+				err := service.Verify("user", totpCode(key, current+offset), "")
+				if !errors.Is(err, tt.want[i]) {
+					t.Errorf("attempt %d (step %+d): error = %v, want %v", i+1, offset, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
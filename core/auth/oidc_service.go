@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"shade_web_server/core/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// OIDCStateTTL bounds how long the user may spend at the identity provider
+const OIDCStateTTL = 10 * time.Minute
+
+var (
+	ErrInvalidOIDCState       = errors.New("invalid or expired OIDC state")
+	ErrOIDCEmailNotVerified   = errors.New("identity provider did not return a verified email")
+	ErrOIDCAccountNotVerified = errors.New("existing account has not verified its email")
+)
+
+// OIDCService signs users in through an external OpenID Connect provider.
+type OIDCService struct {
+	Provider     *OIDCProvider
+	IdentityRepo UserIdentityRepository
+	Auth         *AuthService
+}
+
+// NewOIDCService initializes OIDCService.
+func NewOIDCService(provider *OIDCProvider, repo UserIdentityRepository, authService *AuthService) *OIDCService {
+	return &OIDCService{
+		Provider:     provider,
+		IdentityRepo: repo,
+		Auth:         authService,
+	}
+}
+
+// Begin starts a login. It returns the provider URL to redirect to and a
+// signed state token that the callback must present again. The state token
+// holds the PKCE verifier, so it has to be kept from scripts (HttpOnly cookie).
+func (s *OIDCService) Begin(ctx context.Context) (string, string, error) {
+	state, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := s.Provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	stateToken, err := s.Auth.Keys.Sign(jwt.MapClaims{
+		"jti":      uuid.New().String(),
+		"typ":      TokenTypeOIDCState,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"iat":      now.Unix(),
+		"exp":      now.Add(OIDCStateTTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, stateToken, nil
+}
+
+// Complete checks the state returned by the provider, redeems the code and
+// returns the matching Shade user, creating it on first login. The boolean
+// reports whether the user was created.
+func (s *OIDCService) Complete(ctx context.Context, stateToken, state, code string) (*users.User, bool, error) {
+	claims, err := s.Auth.Keys.Parse(stateToken)
+	if err != nil {
+		return nil, false, ErrInvalidOIDCState
+	}
+
+	if typ, _ := claims["typ"].(string); typ != TokenTypeOIDCState {
+		return nil, false, ErrInvalidOIDCState
+	}
+
+	expectedState, _ := claims["state"].(string)
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if expectedState == "" || expectedState != state || jti == "" || err != nil || expiresAt == nil {
+		return nil, false, ErrInvalidOIDCState
+	}
+
+	// Each state is good for one callback
+	revoked, err := s.Auth.Revocations.IsTokenRevoked(jti)
+	if err != nil {
+		return nil, false, err
+	}
+	if revoked {
+		return nil, false, ErrInvalidOIDCState
+	}
+	if err := s.Auth.Revocations.RevokeToken(jti, expiresAt.Time); err != nil {
+		return nil, false, err
+	}
+
+	identity, err := s.Provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return s.resolveUser(identity)
+}
+
+// resolveUser finds the user linked to an identity, links an existing user
+// whose email is verified on both sides, or creates a new one.
+func (s *OIDCService) resolveUser(identity *OIDCIdentity) (*users.User, bool, error) {
+	now := time.Now()
+
+	link, err := s.IdentityRepo.FindBySubject(identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, false, err
+	}
+	if link != nil {
+		user, err := s.Auth.UserService.GetUserByID(link.UserID)
+		if err != nil {
+			return nil, false, err
+		}
+		if !user.Active() {
+			return nil, false, ErrAccountDisabled
+		}
+		if err := s.IdentityRepo.RecordLogin(link.ID, now); err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, false, ErrOIDCEmailNotVerified
+	}
+
+	created := false
+	user, err := s.Auth.UserService.UserRepo.FindByEmail(identity.Email)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch user by email: %v", err)
+	}
+
+	switch {
+	case user == nil:
+		user, err = s.createUser(identity)
+		if err != nil {
+			return nil, false, err
+		}
+		created = true
+
+	case !user.Active():
+		return nil, false, ErrAccountDisabled
+
+	case !user.EmailVerified():
+		// Anybody can sign up with an address they do not own, and such an
+		// account may already hold sub-users, a second factor and tokens of
+		// the squatter. The owner of the email has to prove it to Shade first.
+		return nil, false, ErrOIDCAccountNotVerified
+	}
+
+	err = s.IdentityRepo.Save(&UserIdentity{
+		UserID:      user.ID,
+		Issuer:      identity.Issuer,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, created, nil
+}
+
+// createUser creates a user for a first single sign-on login. The user gets
+// an unguessable password and can set a real one through the reset flow.
+func (s *OIDCService) createUser(identity *OIDCIdentity) (*users.User, error) {
+	password, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+
+	user, err := s.Auth.UserService.CreateUser(name, identity.Email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Auth.UserService.MarkEmailVerified(user.ID); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+
+	return user, nil
+}
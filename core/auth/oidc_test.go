@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"shade_web_server/core/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	mockClientID    = "shade"
+	mockRedirectURL = "https://shade.example/auth/oidc/callback"
+	mockKeyID       = "idp-key"
+)
+
+// mockIdP is an OpenID provider serving discovery, its keys and the token
+// endpoint. Authorizations are granted directly from the URL Shade built.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	lock    sync.Mutex
+	pending map[string]mockAuthorization // Authorization code -> request it answers
+
+	// Content of the next ID token
+	subject       string
+	email         string
+	emailVerified bool
+	nonce         string // Replaces the nonce of the request when set
+}
+
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIdP(t *testing.T, key *rsa.PrivateKey) *mockIdP {
+	idp := &mockIdP{key: key, pending: make(map[string]mockAuthorization), subject: "idp-user", emailVerified: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize plays the user logging in at the provider and returns the code
+// and state the browser brings back to the callback
+func (idp *mockIdP) authorize(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid authorization URL: %v", err)
+	}
+	query := parsed.Query()
+
+	if query.Get("client_id") != mockClientID || query.Get("redirect_uri") != mockRedirectURL || query.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization request %v", query)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without a PKCE challenge: %v", query)
+	}
+	if query.Get("nonce") == "" || query.Get("state") == "" {
+		t.Fatalf("authorization request without nonce or state: %v", query)
+	}
+
+	code := uuid.New().String()
+	idp.lock.Lock()
+	idp.pending[code] = mockAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	idp.lock.Unlock()
+
+	return code, query.Get("state")
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	idp.lock.Lock()
+	authorization, ok := idp.pending[r.Form.Get("code")]
+	delete(idp.pending, r.Form.Get("code")) // Codes are single use
+	idp.lock.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || r.Form.Get("grant_type") != "authorization_code" ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := authorization.nonce
+	if idp.nonce != "" {
+		nonce = idp.nonce
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            mockClientID,
+		"sub":            idp.subject,
+		"email":          idp.email,
+		"email_verified": idp.emailVerified,
+		"name":           "Ada Lovelace",
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = mockKeyID
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+// memoryIdentities is an in-memory UserIdentityRepository
+type memoryIdentities struct {
+	lock       sync.Mutex
+	identities []*UserIdentity
+}
+
+func (r *memoryIdentities) Save(identity *UserIdentity) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	identity.ID = uuid.New()
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+func (r *memoryIdentities) FindBySubject(issuer, subject string) (*UserIdentity, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, identity := range r.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryIdentities) RecordLogin(id uuid.UUID, at time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, identity := range r.identities {
+		if identity.ID == id {
+			identity.LastLoginAt = &at
+		}
+	}
+	return nil
+}
+
+// newOIDCTest wires an OIDCService to a fresh mock provider
+func newOIDCTest(t *testing.T, key *rsa.PrivateKey, existing ...*users.User) (*OIDCService, *mockIdP, *memoryUsers, *memoryIdentities) {
+	idp := newMockIdP(t, key)
+
+	userRepo := newMemoryUsers(existing...)
+	userService := users.NewUserService(userRepo)
+	userService.Hasher = users.NewArgon2idHasher(users.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	authService := NewAuthService(userService, newMemoryRefreshTokens(), NewMemoryRevocationStore(), newTestKeys(t))
+
+	identities := &memoryIdentities{}
+	provider := NewOIDCProvider(&OIDCConfig{
+		IssuerURL:   idp.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: mockRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+	})
+
+	return NewOIDCService(provider, identities, authService), idp, userRepo, identities
+}
+
+func TestOIDCLogin(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+
+	verifiedAt := time.Now().Add(-time.Hour)
+	verified := &users.User{ID: uuid.New(), Email: "ada@example.com", Role: users.RoleOwner, EmailVerifiedAt: &verifiedAt}
+	unverified := &users.User{ID: uuid.New(), Email: "ada@example.com", Role: users.RoleOwner}
+	disabled := &users.User{ID: uuid.New(), Email: "ada@example.com", Role: users.RoleOwner, EmailVerifiedAt: &verifiedAt, DisabledAt: &verifiedAt}
+
+	tests := []struct {
+		name          string
+		existing      *users.User
+		linked        bool // The identity was linked to existing by an earlier login
+		email         string
+		emailVerified bool
+		wantErr       error
+		wantCreated   bool
+		wantUser      *users.User // nil when a new user is expected
+	}{
+		{name: "first login creates a verified user", email: "ada@example.com", emailVerified: true, wantCreated: true},
+		{name: "links a verified local account", existing: verified, email: "ada@example.com", emailVerified: true, wantUser: verified},
+		{name: "refuses an unverified local account", existing: unverified, email: "ada@example.com", emailVerified: true, wantErr: ErrOIDCAccountNotVerified},
+		{name: "refuses an email the provider did not verify", email: "ada@example.com", emailVerified: false, wantErr: ErrOIDCEmailNotVerified},
+		{name: "refuses a missing email", email: "", emailVerified: true, wantErr: ErrOIDCEmailNotVerified},
+		{name: "linked identity logs in whatever its email", existing: verified, linked: true, email: "changed@example.com", emailVerified: false, wantUser: verified},
+		{name: "linked identity of a disabled user", existing: disabled, linked: true, email: "ada@example.com", emailVerified: true, wantErr: ErrAccountDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var existing []*users.User
+			if tt.existing != nil {
+				copied := *tt.existing
+				existing = append(existing, &copied)
+			}
+			service, idp, userRepo, identities := newOIDCTest(t, key, existing...)
+			idp.email, idp.emailVerified = tt.email, tt.emailVerified
+			if tt.linked {
+				identities.Save(&UserIdentity{UserID: tt.existing.ID, Issuer: idp.server.URL, Subject: idp.subject})
+			}
+
+			authURL, stateToken, err := service.Begin(context.Background())
+			if err != nil {
+				t.Fatalf("Begin: %v", err)
+			}
+			code, state := idp.authorize(t, authURL)
+
+			user, created, err := service.Complete(context.Background(), stateToken, state, code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Complete error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(identities.identities) > 0 && !tt.linked {
+					t.Error("identity linked despite the error")
+				}
+				return
+			}
+
+			if created != tt.wantCreated {
+				t.Errorf("created = %v, want %v", created, tt.wantCreated)
+			}
+			if tt.wantUser != nil && user.ID != tt.wantUser.ID {
+				t.Errorf("logged in as %v, want %v", user.ID, tt.wantUser.ID)
+			}
+			if stored, _ := userRepo.FindByID(user.ID); stored == nil || !stored.EmailVerified() {
+				t.Error("user is not stored with a verified email")
+			}
+			if link, _ := identities.FindBySubject(idp.server.URL, idp.subject); link == nil || link.UserID != user.ID {
+				t.Error("identity is not linked to the user")
+			}
+		})
+	}
+}
+
+func TestOIDCStateAndNonce(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("state token is single use", func(t *testing.T) {
+		service, idp, _, _ := newOIDCTest(t, key)
+		idp.email = "ada@example.com"
+
+		authURL, stateToken, _ := service.Begin(ctx)
+		code, state := idp.authorize(t, authURL)
+		if _, _, err := service.Complete(ctx, stateToken, state, code); err != nil {
+			t.Fatalf("first callback: %v", err)
+		}
+
+		// A replayed callback fails before the provider is asked again
+		if _, _, err := service.Complete(ctx, stateToken, state, code); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("replayed callback error = %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("state must match the state token", func(t *testing.T) {
+		service, idp, _, _ := newOIDCTest(t, key)
+		idp.email = "ada@example.com"
+
+		authURL, stateToken, _ := service.Begin(ctx)
+		code, _ := idp.authorize(t, authURL)
+		if _, _, err := service.Complete(ctx, stateToken, "forged", code); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("error = %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("state token of another login flow", func(t *testing.T) {
+		service, idp, _, _ := newOIDCTest(t, key)
+		idp.email = "ada@example.com"
+
+		_, otherStateToken, _ := service.Begin(ctx)
+		authURL, _, _ := service.Begin(ctx)
+		code, state := idp.authorize(t, authURL)
+		if _, _, err := service.Complete(ctx, otherStateToken, state, code); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("error = %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("ID token must carry the nonce", func(t *testing.T) {
+		service, idp, _, _ := newOIDCTest(t, key)
+		idp.email = "ada@example.com"
+		idp.nonce = "replayed-token-nonce"
+
+		authURL, stateToken, _ := service.Begin(ctx)
+		code, state := idp.authorize(t, authURL)
+		if _, _, err := service.Complete(ctx, stateToken, state, code); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("code is only redeemed with the PKCE verifier", func(t *testing.T) {
+		service, idp, _, _ := newOIDCTest(t, key)
+		idp.email = "ada@example.com"
+
+		authURL, _, _ := service.Begin(ctx)
+		code, _ := idp.authorize(t, authURL)
+
+		// A stolen code without the verifier from the state cookie is useless
+		query, _ := url.Parse(authURL)
+		if _, err := service.Provider.Exchange(ctx, code, "wrong-verifier", query.Query().Get("nonce")); err == nil {
+			t.Error("code redeemed with the wrong verifier")
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetToken is a single-use token emailed to a user who forgot their password.
+type PasswordResetToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
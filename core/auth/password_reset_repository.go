@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetRepository defines methods for persisting password reset tokens.
+type PasswordResetRepository interface {
+	Save(token *PasswordResetToken) error                   // Store a new token
+	FindByHash(hash string) (*PasswordResetToken, error)    // Retrieve a token by its hash, nil if missing
+	MarkUsed(id uuid.UUID, at time.Time) (bool, error)      // Consume a token, false if it was already used
+	InvalidateForUser(userID uuid.UUID, at time.Time) error // Consume every pending token of a user
+}
+
+// MySQLPasswordResetRepository is the implementation of PasswordResetRepository using MySQL.
+type MySQLPasswordResetRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLPasswordResetRepository creates a new MySQLPasswordResetRepository.
+func NewMySQLPasswordResetRepository(db *sql.DB) *MySQLPasswordResetRepository {
+	return &MySQLPasswordResetRepository{DB: db}
+}
+
+// Save stores a password reset token.
+func (repo *MySQLPasswordResetRepository) Save(token *PasswordResetToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	if _, err := repo.DB.Exec(query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to save password reset token: %v", err)
+	}
+
+	return nil
+}
+
+// FindByHash retrieves a password reset token by the hash of its value.
+func (repo *MySQLPasswordResetRepository) FindByHash(hash string) (*PasswordResetToken, error) {
+	query := `
+		SELECT id, user_id, token_hash, expires_at, used_at
+		FROM password_reset_tokens
+		WHERE token_hash = ?
+	`
+
+	var token PasswordResetToken
+	var usedAt sql.NullTime
+	err := repo.DB.QueryRow(query, hash).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no token is found
+		}
+		return nil, fmt.Errorf("failed to find password reset token: %v", err)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	return &token, nil
+}
+
+// MarkUsed consumes a token, the conditional update guarantees a single use.
+func (repo *MySQLPasswordResetRepository) MarkUsed(id uuid.UUID, at time.Time) (bool, error) {
+	result, err := repo.DB.Exec(`UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL`, at, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to use password reset token: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use password reset token: %v", err)
+	}
+
+	return affected == 1, nil
+}
+
+// InvalidateForUser consumes every pending token of a user, so only the most
+// recent email can be used.
+func (repo *MySQLPasswordResetRepository) InvalidateForUser(userID uuid.UUID, at time.Time) error {
+	_, err := repo.DB.Exec(`UPDATE password_reset_tokens SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, at, userID)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate password reset tokens: %v", err)
+	}
+
+	return nil
+}
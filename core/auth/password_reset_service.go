@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"shade_web_server/core/mail"
+)
+
+const (
+	PasswordResetTTL  = time.Hour
+	MinPasswordLength = 8
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	ErrWeakPassword      = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+)
+
+// PasswordResetService lets users who forgot their password choose a new one
+// through a link sent by email.
+type PasswordResetService struct {
+	ResetRepo PasswordResetRepository
+	Auth      *AuthService
+	Mailer    mail.Mailer
+	BaseURL   string // Frontend URL the reset link points to
+}
+
+// NewPasswordResetService initializes PasswordResetService.
+func NewPasswordResetService(repo PasswordResetRepository, authService *AuthService, mailer mail.Mailer, baseURL string) *PasswordResetService {
+	return &PasswordResetService{
+		ResetRepo: repo,
+		Auth:      authService,
+		Mailer:    mailer,
+		BaseURL:   baseURL,
+	}
+}
+
+// RequestReset emails a reset link to the user. Unknown emails are silently
+// ignored so the endpoint cannot be used to discover accounts.
+func (s *PasswordResetService) RequestReset(email string) error {
+	user, err := s.Auth.UserService.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	// Only the most recent link stays valid
+	if err := s.ResetRepo.InvalidateForUser(user.ID, now); err != nil {
+		return err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.ResetRepo.Save(&PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(PasswordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := s.BaseURL + "/reset-password?token=" + url.QueryEscape(token)
+
+	return s.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Shade password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomebody asked to reset the password of your Shade account. "+
+			"Open the link below within %d minutes to choose a new one:\n\n%s\n\n"+
+			"If it was not you, you can ignore this email.\n",
+			user.Name, int(PasswordResetTTL.Minutes()), link),
+	})
+}
+
+// ResetPassword sets a new password using a reset token and logs the user
+// out of every existing session.
+func (s *PasswordResetService) ResetPassword(token, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+
+	stored, err := s.ResetRepo.FindByHash(hashToken(token))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	used, err := s.ResetRepo.MarkUsed(stored.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.Auth.UserService.UpdatePassword(stored.UserID, password); err != nil {
+		return err
+	}
+
+	return s.Auth.LogoutAll(stored.UserID.String())
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PersonalAccessTokenPrefix marks bearer tokens that are not JWTs.
+const PersonalAccessTokenPrefix = "shade_pat_"
+
+// Scopes a personal access token can be granted
+const (
+	ScopeContainersRead  = "containers:read"  // List containers, read their status and metrics
+	ScopeContainersWrite = "containers:write" // Create, delete, stop, start and restart containers
+)
+
+// AllScopes lists every scope a token can be granted.
+var AllScopes = []string{ScopeContainersRead, ScopeContainersWrite}
+
+// PersonalAccessToken is a long-lived, scoped token meant for automation
+// such as CI pipelines.
+type PersonalAccessToken struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"-"`
+	Name        string     `json:"name"`
+	TokenHash   string     `json:"-"`
+	TokenPrefix string     `json:"token_prefix"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP  string     `json:"last_used_ip,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted a scope.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreatePersonalAccessToken struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
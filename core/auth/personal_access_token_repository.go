@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PersonalAccessTokenRepository defines methods for persisting personal access tokens.
+type PersonalAccessTokenRepository interface {
+	Save(token *PersonalAccessToken) error                          // Store a new token
+	FindByHash(hash string) (*PersonalAccessToken, error)           // Retrieve a token by its hash, nil if missing
+	FindAllByUser(userID uuid.UUID) ([]*PersonalAccessToken, error) // List every token of a user
+	Revoke(userID, id uuid.UUID, at time.Time) (bool, error)        // Revoke a token of a user, false if not found
+	RevokeAllForUser(userID uuid.UUID, at time.Time) error          // Revoke every token of a user
+	RecordUsage(id uuid.UUID, at time.Time, ip string) error        // Store when and from where a token was used
+}
+
+// MySQLPersonalAccessTokenRepository is the implementation of PersonalAccessTokenRepository using MySQL.
+type MySQLPersonalAccessTokenRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLPersonalAccessTokenRepository creates a new MySQLPersonalAccessTokenRepository.
+func NewMySQLPersonalAccessTokenRepository(db *sql.DB) *MySQLPersonalAccessTokenRepository {
+	return &MySQLPersonalAccessTokenRepository{DB: db}
+}
+
+const personalAccessTokenColumns = `id, user_id, name, token_hash, token_prefix, scopes, expires_at, last_used_at, last_used_ip, revoked_at, created_at`
+
+// Save stores a personal access token.
+func (repo *MySQLPersonalAccessTokenRepository) Save(token *PersonalAccessToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO personal_access_tokens (id, user_id, name, token_hash, token_prefix, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := repo.DB.Exec(query,
+		token.ID, token.UserID, token.Name, token.TokenHash, token.TokenPrefix,
+		strings.Join(token.Scopes, " "), token.ExpiresAt, token.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save personal access token: %v", err)
+	}
+
+	return nil
+}
+
+// FindByHash retrieves a personal access token by the hash of its value.
+func (repo *MySQLPersonalAccessTokenRepository) FindByHash(hash string) (*PersonalAccessToken, error) {
+	query := `SELECT ` + personalAccessTokenColumns + ` FROM personal_access_tokens WHERE token_hash = ?`
+
+	token, err := scanPersonalAccessToken(repo.DB.QueryRow(query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no token is found
+		}
+		return nil, fmt.Errorf("failed to find personal access token: %v", err)
+	}
+
+	return token, nil
+}
+
+// FindAllByUser lists the tokens of a user, newest first.
+func (repo *MySQLPersonalAccessTokenRepository) FindAllByUser(userID uuid.UUID) ([]*PersonalAccessToken, error) {
+	query := `SELECT ` + personalAccessTokenColumns + ` FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC`
+
+	rows, err := repo.DB.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch personal access tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan personal access token: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while reading rows: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// Revoke revokes a token, scoped to its owner so users cannot revoke each other's tokens.
+func (repo *MySQLPersonalAccessTokenRepository) Revoke(userID, id uuid.UUID, at time.Time) (bool, error) {
+	query := `
+		UPDATE personal_access_tokens
+		SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+
+	result, err := repo.DB.Exec(query, at, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke personal access token: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke personal access token: %v", err)
+	}
+
+	return affected == 1, nil
+}
+
+// RevokeAllForUser revokes every token of a user.
+func (repo *MySQLPersonalAccessTokenRepository) RevokeAllForUser(userID uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE personal_access_tokens
+		SET revoked_at = ?
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+
+	if _, err := repo.DB.Exec(query, at, userID); err != nil {
+		return fmt.Errorf("failed to revoke personal access tokens: %v", err)
+	}
+
+	return nil
+}
+
+// RecordUsage stores the last use of a token. Busy pipelines hit the API
+// constantly, so the row is only written when the IP changed or the
+// previous write is more than a minute old.
+func (repo *MySQLPersonalAccessTokenRepository) RecordUsage(id uuid.UUID, at time.Time, ip string) error {
+	query := `
+		UPDATE personal_access_tokens
+		SET last_used_at = ?, last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ? OR last_used_ip <> ?)
+	`
+
+	if _, err := repo.DB.Exec(query, at, ip, id, at.Add(-time.Minute), ip); err != nil {
+		return fmt.Errorf("failed to record personal access token usage: %v", err)
+	}
+
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	var lastUsedIP sql.NullString
+
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.Name, &token.TokenHash, &token.TokenPrefix, &scopes,
+		&token.ExpiresAt, &lastUsedAt, &lastUsedIP, &revokedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.LastUsedIP = lastUsedIP.String
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
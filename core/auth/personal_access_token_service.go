@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shade_web_server/core/users"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPersonalAccessTokenDays = 30
+	MaxPersonalAccessTokenDays     = 365
+)
+
+var (
+	ErrInvalidPersonalAccessToken = errors.New("invalid, expired or revoked personal access token")
+	ErrPersonalAccessTokenMissing = errors.New("personal access token not found")
+	ErrInvalidTokenRequest        = errors.New("invalid personal access token request")
+)
+
+// PersonalAccessTokenService manages the tokens users create for automation.
+type PersonalAccessTokenService struct {
+	PATRepo     PersonalAccessTokenRepository
+	UserService *users.UserService
+}
+
+// NewPersonalAccessTokenService initializes PersonalAccessTokenService.
+func NewPersonalAccessTokenService(repo PersonalAccessTokenRepository, userService *users.UserService) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{
+		PATRepo:     repo,
+		UserService: userService,
+	}
+}
+
+// Create issues a new token. The plain token is returned only here, only its
+// hash is stored.
+func (s *PersonalAccessTokenService) Create(userID uuid.UUID, request CreatePersonalAccessToken) (string, *PersonalAccessToken, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > 100 {
+		return "", nil, fmt.Errorf("%w: name must be between 1 and 100 characters", ErrInvalidTokenRequest)
+	}
+
+	if len(request.Scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidTokenRequest)
+	}
+	for _, scope := range request.Scopes {
+		if !validScope(scope) {
+			return "", nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidTokenRequest, scope)
+		}
+	}
+
+	days := request.ExpiresInDays
+	if days == 0 {
+		days = DefaultPersonalAccessTokenDays
+	}
+	if days < 0 || days > MaxPersonalAccessTokenDays {
+		return "", nil, fmt.Errorf("%w: expires_in_days must be between 1 and %d", ErrInvalidTokenRequest, MaxPersonalAccessTokenDays)
+	}
+
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	plain := PersonalAccessTokenPrefix + secret
+
+	now := time.Now()
+	token := &PersonalAccessToken{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Name:        name,
+		TokenHash:   hashToken(plain),
+		TokenPrefix: plain[:len(PersonalAccessTokenPrefix)+4],
+		Scopes:      request.Scopes,
+		ExpiresAt:   now.AddDate(0, 0, days),
+		CreatedAt:   now,
+	}
+
+	if err := s.PATRepo.Save(token); err != nil {
+		return "", nil, err
+	}
+
+	return plain, token, nil
+}
+
+// List returns every token of a user, including expired and revoked ones.
+func (s *PersonalAccessTokenService) List(userID uuid.UUID) ([]*PersonalAccessToken, error) {
+	return s.PATRepo.FindAllByUser(userID)
+}
+
+// Revoke revokes one of the user's tokens.
+func (s *PersonalAccessTokenService) Revoke(userID, id uuid.UUID) error {
+	revoked, err := s.PATRepo.Revoke(userID, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrPersonalAccessTokenMissing
+	}
+	return nil
+}
+
+// RevokeAll revokes every token of a user.
+func (s *PersonalAccessTokenService) RevokeAll(userID uuid.UUID) error {
+	return s.PATRepo.RevokeAllForUser(userID, time.Now())
+}
+
+// Authenticate validates a token presented as a bearer token and records its
+// use. It also returns the owner, whose current role limits the token.
+func (s *PersonalAccessTokenService) Authenticate(plain, ip string) (*PersonalAccessToken, *users.User, error) {
+	token, err := s.PATRepo.FindByHash(hashToken(plain))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if token == nil || token.RevokedAt != nil || now.After(token.ExpiresAt) {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	user, err := s.UserService.GetUserByID(token.UserID)
+	if err != nil || !user.Active() {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	if err := s.PATRepo.RecordUsage(token.ID, now, ip); err != nil {
+		return nil, nil, err
+	}
+
+	return token, user, nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is a long-lived, single-use token exchanged for a new access token.
+// Only the SHA-256 hash of the token is ever persisted.
+type RefreshToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	FamilyID  uuid.UUID // All tokens rotated from the same login share a family
+	DeviceID  uuid.UUID // Device the login came from, uuid.Nil when unknown
+	TokenHash string
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// TokenPair is returned to clients on login and on every refresh.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Access token lifetime in seconds
+
+	FamilyID uuid.UUID `json:"-"` // Lets the login attach the session to its device
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshTokenRepository defines methods for persisting refresh tokens.
+type RefreshTokenRepository interface {
+	Save(token *RefreshToken) error                              // Store a newly issued token
+	FindByHash(hash string) (*RefreshToken, error)               // Retrieve a token by its hash, nil if missing
+	MarkRotated(id uuid.UUID, at time.Time) (bool, error)        // Consume a token, false if it was already used
+	RevokeFamily(familyID uuid.UUID, at time.Time) error         // Revoke every token of a family
+	RevokeAllForUser(userID uuid.UUID, at time.Time) error       // Revoke every token of a user
+	AttachDevice(familyID, deviceID uuid.UUID) error             // Record the device a family was issued to
+	RevokeDevice(userID, deviceID uuid.UUID, at time.Time) error // Revoke every token of a user issued to a device
+}
+
+// MySQLRefreshTokenRepository is the implementation of RefreshTokenRepository using MySQL.
+type MySQLRefreshTokenRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLRefreshTokenRepository creates a new MySQLRefreshTokenRepository.
+func NewMySQLRefreshTokenRepository(db *sql.DB) *MySQLRefreshTokenRepository {
+	return &MySQLRefreshTokenRepository{DB: db}
+}
+
+// Save stores a refresh token in the database.
+func (repo *MySQLRefreshTokenRepository) Save(token *RefreshToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, family_id, device_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	var deviceID interface{} // NULL rather than the nil UUID
+	if token.DeviceID != uuid.Nil {
+		deviceID = token.DeviceID
+	}
+
+	_, err := repo.DB.Exec(query, token.ID, token.UserID, token.FamilyID, deviceID, token.TokenHash, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save refresh token: %v", err)
+	}
+
+	return nil
+}
+
+// FindByHash retrieves a refresh token by the hash of its value.
+func (repo *MySQLRefreshTokenRepository) FindByHash(hash string) (*RefreshToken, error) {
+	query := `
+		SELECT id, user_id, family_id, device_id, token_hash, expires_at, rotated_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = ?
+	`
+
+	var token RefreshToken
+	var rotatedAt, revokedAt sql.NullTime
+	err := repo.DB.QueryRow(query, hash).Scan(
+		&token.ID, &token.UserID, &token.FamilyID, &token.DeviceID, &token.TokenHash,
+		&token.ExpiresAt, &rotatedAt, &revokedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no token is found
+		}
+		return nil, fmt.Errorf("failed to find refresh token: %v", err)
+	}
+
+	if rotatedAt.Valid {
+		token.RotatedAt = &rotatedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// MarkRotated consumes a token. The conditional update makes sure that two
+// concurrent refreshes with the same token cannot both succeed.
+func (repo *MySQLRefreshTokenRepository) MarkRotated(id uuid.UUID, at time.Time) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET rotated_at = ?
+		WHERE id = ? AND rotated_at IS NULL AND revoked_at IS NULL
+	`
+
+	result, err := repo.DB.Exec(query, at, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+
+	return affected == 1, nil
+}
+
+// RevokeFamily revokes every token that descends from the same login.
+func (repo *MySQLRefreshTokenRepository) RevokeFamily(familyID uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = ?
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+
+	_, err := repo.DB.Exec(query, at, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every refresh token of a user.
+func (repo *MySQLRefreshTokenRepository) RevokeAllForUser(userID uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = ?
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+
+	_, err := repo.DB.Exec(query, at, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+
+	return nil
+}
+
+// AttachDevice records the device a token family was issued to, the tokens
+// rotated from it later inherit the device.
+func (repo *MySQLRefreshTokenRepository) AttachDevice(familyID, deviceID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET device_id = ?
+		WHERE family_id = ?
+	`
+
+	_, err := repo.DB.Exec(query, deviceID, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to attach refresh tokens to device: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeDevice revokes every refresh token of a user issued to a device.
+func (repo *MySQLRefreshTokenRepository) RevokeDevice(userID, deviceID uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = ?
+		WHERE user_id = ? AND device_id = ? AND revoked_at IS NULL
+	`
+
+	_, err := repo.DB.Exec(query, at, userID, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens of device: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RevocationStore keeps track of access tokens that must no longer be accepted
+// even though their signature and expiry are still valid.
+type RevocationStore interface {
+	RevokeToken(jti string, expiresAt time.Time) error      // Revoke a single token until it expires
+	IsTokenRevoked(jti string) (bool, error)                // Check whether a token was revoked
+	RevokeAllForUser(userID string, before time.Time) error // Revoke every token of a user issued before a time
+	RevokedBefore(userID string) (time.Time, error)         // Cut-off set by RevokeAllForUser, zero if none
+}
+
+// MemoryRevocationStore is an in-process RevocationStore, suited for a single replica.
+type MemoryRevocationStore struct {
+	tokens map[string]time.Time // jti -> expiry
+	users  map[string]time.Time // user ID -> cut-off
+	lock   sync.RWMutex
+}
+
+// NewMemoryRevocationStore creates an empty MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+	}
+}
+
+// RevokeToken marks a token as revoked and drops entries that already expired.
+func (s *MemoryRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for id, expiry := range s.tokens {
+		if now.After(expiry) {
+			delete(s.tokens, id)
+		}
+	}
+
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+// IsTokenRevoked checks whether a token was revoked.
+func (s *MemoryRevocationStore) IsTokenRevoked(jti string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, revoked := s.tokens[jti]
+	return revoked, nil
+}
+
+// RevokeAllForUser stores the cut-off for a user.
+func (s *MemoryRevocationStore) RevokeAllForUser(userID string, before time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.users[userID] = before.Truncate(time.Second) // Same precision as the MySQL store
+	return nil
+}
+
+// RevokedBefore returns the cut-off for a user.
+func (s *MemoryRevocationStore) RevokedBefore(userID string) (time.Time, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.users[userID], nil
+}
+
+// MySQLRevocationStore is the implementation of RevocationStore using MySQL,
+// shared by every replica.
+type MySQLRevocationStore struct {
+	DB *sql.DB
+}
+
+// NewMySQLRevocationStore creates a new MySQLRevocationStore.
+func NewMySQLRevocationStore(db *sql.DB) *MySQLRevocationStore {
+	return &MySQLRevocationStore{DB: db}
+}
+
+// RevokeToken stores a revoked token and drops entries that already expired.
+func (s *MySQLRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	if _, err := s.DB.Exec(`DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now()); err != nil {
+		return fmt.Errorf("failed to prune revoked tokens: %v", err)
+	}
+
+	query := `
+		INSERT INTO revoked_tokens (jti, expires_at)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE expires_at = VALUES(expires_at)
+	`
+
+	if _, err := s.DB.Exec(query, jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked checks whether a token was revoked.
+func (s *MySQLRevocationStore) IsTokenRevoked(jti string) (bool, error) {
+	var found int
+	err := s.DB.QueryRow(`SELECT 1 FROM revoked_tokens WHERE jti = ?`, jti).Scan(&found)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+
+	return true, nil
+}
+
+// RevokeAllForUser stores the cut-off for a user.
+func (s *MySQLRevocationStore) RevokeAllForUser(userID string, before time.Time) error {
+	query := `
+		INSERT INTO user_session_revocations (user_id, revoked_before)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE revoked_before = VALUES(revoked_before)
+	`
+
+	// The column has no fractional seconds, MySQL would round instead
+	if _, err := s.DB.Exec(query, userID, before.Truncate(time.Second)); err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %v", err)
+	}
+
+	return nil
+}
+
+// RevokedBefore returns the cut-off for a user.
+func (s *MySQLRevocationStore) RevokedBefore(userID string) (time.Time, error) {
+	var before time.Time
+	err := s.DB.QueryRow(`SELECT revoked_before FROM user_session_revocations WHERE user_id = ?`, userID).Scan(&before)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to fetch user session revocation: %v", err)
+	}
+
+	return before, nil
+}
@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links a Shade user to an account at an external identity provider.
+type UserIdentity struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Issuer      string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentityRepository defines methods for persisting links to external identities.
+type UserIdentityRepository interface {
+	Save(identity *UserIdentity) error                           // Link an external identity to a user
+	FindBySubject(issuer, subject string) (*UserIdentity, error) // Retrieve a link, nil if missing
+	RecordLogin(id uuid.UUID, at time.Time) error                // Store the time of the last login
+}
+
+// MySQLUserIdentityRepository is the implementation of UserIdentityRepository using MySQL.
+type MySQLUserIdentityRepository struct {
+	DB *sql.DB
+}
+
+// NewMySQLUserIdentityRepository creates a new MySQLUserIdentityRepository.
+func NewMySQLUserIdentityRepository(db *sql.DB) *MySQLUserIdentityRepository {
+	return &MySQLUserIdentityRepository{DB: db}
+}
+
+// Save stores a link between a user and an external identity.
+func (repo *MySQLUserIdentityRepository) Save(identity *UserIdentity) error {
+	if identity.ID == uuid.Nil {
+		identity.ID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO user_identities (id, user_id, issuer, subject, email, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := repo.DB.Exec(query, identity.ID, identity.UserID, identity.Issuer, identity.Subject, identity.Email, identity.LastLoginAt)
+	if err != nil {
+		return fmt.Errorf("failed to save user identity: %v", err)
+	}
+
+	return nil
+}
+
+// FindBySubject retrieves the link for a subject at an issuer.
+func (repo *MySQLUserIdentityRepository) FindBySubject(issuer, subject string) (*UserIdentity, error) {
+	query := `
+		SELECT id, user_id, issuer, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?
+	`
+
+	var identity UserIdentity
+	var lastLoginAt sql.NullTime
+	err := repo.DB.QueryRow(query, issuer, subject).Scan(
+		&identity.ID, &identity.UserID, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt, &lastLoginAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if no identity is found
+		}
+		return nil, fmt.Errorf("failed to find user identity: %v", err)
+	}
+
+	if lastLoginAt.Valid {
+		identity.LastLoginAt = &lastLoginAt.Time
+	}
+
+	return &identity, nil
+}
+
+// RecordLogin stores the time of the last login through the identity.
+func (repo *MySQLUserIdentityRepository) RecordLogin(id uuid.UUID, at time.Time) error {
+	if _, err := repo.DB.Exec(`UPDATE user_identities SET last_login_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to record identity login: %v", err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -44,6 +45,7 @@ type ContainerRepository interface {
 	Start(namespace, name string) error                   // Start a stopped container
 	GetAllByNamespace(namespace string) ([]*Container, error)
 	GetMetrics(namespace, name string) (*ContainerMetrics, error)
+	DeleteAll(namespace string) error // Remove every deployment and service of a namespace
 
 	// Error encountered when trying to pause a deployment: No supported methods in K8 API
 	// Pause(namespace, name string) error                   // Pause a container while maintaining state
@@ -340,6 +342,34 @@ func (cluster KubernetesContainerRepository) Delete(namespace, name string) erro
 	return nil
 }
 
+// Deletes every deployment and service in a namespace, used when an account
+// is deleted. A missing namespace has nothing left to delete.
+func (cluster KubernetesContainerRepository) DeleteAll(namespace string) error {
+	deploymentClient := cluster.CS.AppsV1().Deployments(namespace)
+	if err := deploymentClient.DeleteCollection(context.Background(), metav1.DeleteOptions{}, metav1.ListOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete deployments: %v", err)
+	}
+
+	// Services do not support DeleteCollection
+	serviceClient := cluster.CS.CoreV1().Services(namespace)
+	services, err := serviceClient.List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+
+	for _, service := range services.Items {
+		err := serviceClient.Delete(context.Background(), service.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service %q: %v", service.Name, err)
+		}
+	}
+
+	return nil
+}
+
 // Stops a deployment
 func (cluster KubernetesContainerRepository) Stop(namespace, name string) error {
 
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// LogMailer writes emails to a writer instead of sending them, so links in
+// them can be followed during local development.
+type LogMailer struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+// NewLogMailer creates a LogMailer writing to out.
+func NewLogMailer(out io.Writer) *LogMailer {
+	return &LogMailer{out: out}
+}
+
+// Send writes the message to the underlying writer.
+func (m *LogMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, err := fmt.Fprintf(m.out, "----- mail %s -----\nTo: %s\nSubject: %s\n\n%s\n-------------------\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write mail: %v", err)
+	}
+
+	return nil
+}
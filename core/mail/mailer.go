@@ -0,0 +1,54 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer defines how emails leave the application.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailerFromEnv selects the mailer with MAIL_DRIVER: "smtp" sends real
+// emails, "log" (the default) writes them to MAIL_LOG_FILE or stdout for
+// local development.
+func NewMailerFromEnv() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		return NewSMTPMailerFromEnv()
+	case "", "log":
+		path := os.Getenv("MAIL_LOG_FILE")
+		if path == "" {
+			return NewLogMailer(os.Stdout), nil
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open mail log file: %v", err)
+		}
+		return NewLogMailer(file), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// validate rejects messages that could inject extra headers.
+func (m Message) validate() error {
+	if m.To == "" {
+		return errors.New("missing recipient")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("invalid characters in mail headers")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP relay. smtp.SendMail upgrades the
+// connection with STARTTLS whenever the server offers it.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPMailer creates an SMTPMailer.
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+	}
+}
+
+// NewSMTPMailerFromEnv reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM.
+func NewSMTPMailerFromEnv() (*SMTPMailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	from := os.Getenv("MAIL_FROM")
+	if host == "" || from == "" {
+		return nil, errors.New("SMTP_HOST and MAIL_FROM are required for the smtp mail driver")
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+}
+
+// Send delivers the message.
+func (m *SMTPMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + m.From + "\r\n")
+	body.WriteString("To: " + msg.To + "\r\n")
+	body.WriteString("Subject: " + msg.Subject + "\r\n")
+	body.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	err := smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{msg.To}, []byte(body.String()))
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %v", err)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -20,6 +21,7 @@ func NewKubernetesNamespaceRepository(clientset *kubernetes.Clientset) Kubernete
 type NamespaceRepository interface {
 	CreateNamespace(name string) error
 	Exists(name string) (bool, error)
+	DeleteNamespace(name string) (bool, error) // Returns true once the namespace is gone
 }
 
 func (repo KubernetesNamespaceRepository) CreateNamespace(name string) error {
@@ -53,3 +55,29 @@ func (repo KubernetesNamespaceRepository) Exists(name string) (bool, error) {
 
 	return true, nil
 }
+
+// DeleteNamespace asks Kubernetes to delete a namespace. Deletion happens in
+// the background, so it reports whether the namespace is already gone and
+// callers check again until it is.
+func (repo KubernetesNamespaceRepository) DeleteNamespace(name string) (bool, error) {
+	namespaceClient := repo.CS.CoreV1().Namespaces()
+
+	namespace, err := namespaceClient.Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Already terminating, nothing to do but wait
+	if namespace.Status.Phase == apiv1.NamespaceTerminating {
+		return false, nil
+	}
+
+	err = namespaceClient.Delete(context.Background(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	return false, err
+}
@@ -19,3 +19,8 @@ func (s NamespaceService) Exists(name string) bool {
 	}
 	return exists
 }
+
+// DeleteNamespace starts deleting a namespace and reports whether it is gone.
+func (s NamespaceService) DeleteNamespace(name string) (bool, error) {
+	return s.NamespaceRepo.DeleteNamespace(name)
+}
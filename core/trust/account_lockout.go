@@ -0,0 +1,230 @@
+package trust
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys of the lockout in the failure store, followed by the normalized email
+const (
+	lockoutFailuresPrefix = "lockout:failures:"
+	lockoutLocksPrefix    = "lockout:locks:"
+)
+
+// LockNotifier is called when an account gets locked, by the replica that
+// locked it. key is the normalized email.
+type LockNotifier func(key string, until time.Time, lockCount int)
+
+// AccountLock describes a locked account.
+type AccountLock struct {
+	Key         string    `json:"email"`
+	LockedUntil time.Time `json:"locked_until"`
+	LockCount   int       `json:"lock_count"` // Locks in a row, drives the backoff
+}
+
+// AccountLockout tracks failed logins per account, independently of the
+// client IP, so rotating IPs does not give an attacker unlimited guesses.
+// Every threshold failures lock the account, and each lock in a row lasts
+// twice as long as the previous one, up to maxDuration.
+//
+// Failures and locks are kept in a FailureStore, so with a shared store
+// lockouts survive restarts and apply on every replica. Store errors fail
+// open like the FailedLoginTracker.
+type AccountLockout struct {
+	store        FailureStore
+	lock         sync.Mutex
+	threshold    int
+	baseDuration time.Duration
+	maxDuration  time.Duration
+	forgetAfter  time.Duration // Time after which a failure or an ended lock is forgotten
+	notify       LockNotifier
+	onError      StoreErrorHandler
+}
+
+// NewAccountLockout initializes the lockout with the in-memory store
+func NewAccountLockout(threshold int, baseDuration, maxDuration, forgetAfter time.Duration) *AccountLockout {
+	return &AccountLockout{
+		store:        NewMemoryFailureStore(),
+		threshold:    threshold,
+		baseDuration: baseDuration,
+		maxDuration:  maxDuration,
+		forgetAfter:  forgetAfter,
+	}
+}
+
+// SetStore replaces the store, main calls it before serving requests
+func (l *AccountLockout) SetStore(store FailureStore) {
+	l.store = store
+}
+
+// SetNotifier registers the hook called when an account gets locked
+func (l *AccountLockout) SetNotifier(notify LockNotifier) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.notify = notify
+}
+
+// SetErrorHandler registers the function told about store errors, main
+// calls it before serving requests
+func (l *AccountLockout) SetErrorHandler(handler StoreErrorHandler) {
+	l.onError = handler
+}
+
+// RecordFailure counts a failed login and locks the account once the
+// threshold is reached. It returns the end of the lock, zero if not locked.
+func (l *AccountLockout) RecordFailure(email string) time.Time {
+	key := lockoutKey(email)
+	now := time.Now()
+
+	// Failures while locked are rejected before the password is checked,
+	// they do not count towards the next lock
+	until, _, err := l.lockedUntil(key, now)
+	if err != nil {
+		l.failed(key, err)
+		return time.Time{}
+	}
+	if now.Before(until) {
+		return until
+	}
+
+	failures, err := l.store.Add(lockoutFailuresPrefix+key, now, l.forgetAfter)
+	if err != nil {
+		l.failed(key, err)
+		return time.Time{}
+	}
+	if failures < l.threshold {
+		return time.Time{}
+	}
+
+	if err := l.store.Reset(lockoutFailuresPrefix + key); err != nil {
+		l.failed(key, err)
+		return time.Time{}
+	}
+	lockCount, err := l.store.Add(lockoutLocksPrefix+key, now, l.lockWindow())
+	if err != nil {
+		l.failed(key, err)
+		return time.Time{}
+	}
+	until = now.Add(l.lockDuration(lockCount))
+
+	l.lock.Lock()
+	notify := l.notify
+	l.lock.Unlock()
+
+	if notify != nil {
+		notify(key, until, lockCount)
+	}
+	return until
+}
+
+// IsLocked reports whether the account is locked and for how long
+func (l *AccountLockout) IsLocked(email string) (bool, time.Duration) {
+	now := time.Now()
+
+	key := lockoutKey(email)
+	until, _, err := l.lockedUntil(key, now)
+	if err != nil {
+		l.failed(key, err)
+		return false, 0
+	}
+	if !now.Before(until) {
+		return false, 0
+	}
+	return true, until.Sub(now)
+}
+
+// ResetFailures forgets the failures and locks of an account after a
+// successful login
+func (l *AccountLockout) ResetFailures(email string) {
+	key := lockoutKey(email)
+	for _, prefix := range []string{lockoutFailuresPrefix, lockoutLocksPrefix} {
+		if err := l.store.Reset(prefix + key); err != nil {
+			l.failed(key, err)
+		}
+	}
+}
+
+// Unlock lifts the lock of an account, false if it was not locked
+func (l *AccountLockout) Unlock(email string) bool {
+	locked, _ := l.IsLocked(email)
+	l.ResetFailures(email)
+	return locked
+}
+
+// LockedAccounts lists the accounts locked right now, the longest lock first
+func (l *AccountLockout) LockedAccounts() []AccountLock {
+	now := time.Now()
+	locks := []AccountLock{}
+
+	keys, err := l.store.Keys(lockoutLocksPrefix, now.Add(-l.lockWindow()))
+	if err != nil {
+		l.failed(lockoutLocksPrefix, err)
+		return locks
+	}
+
+	for _, storeKey := range keys {
+		key := strings.TrimPrefix(storeKey, lockoutLocksPrefix)
+		until, lockCount, err := l.lockedUntil(key, now)
+		if err != nil {
+			l.failed(key, err)
+			continue
+		}
+		if now.Before(until) {
+			locks = append(locks, AccountLock{Key: key, LockedUntil: until, LockCount: lockCount})
+		}
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].LockedUntil.After(locks[j].LockedUntil)
+	})
+	return locks
+}
+
+// lockedUntil returns the end of the last lock of an account, zero if it was
+// never locked, and the locks in a row
+func (l *AccountLockout) lockedUntil(key string, now time.Time) (time.Time, int, error) {
+	since := now.Add(-l.lockWindow())
+
+	lockCount, err := l.store.Count(lockoutLocksPrefix+key, since)
+	if err != nil || lockCount == 0 {
+		return time.Time{}, 0, err
+	}
+
+	lastLock, err := l.store.Newest(lockoutLocksPrefix+key, since)
+	if err != nil || lastLock.IsZero() {
+		return time.Time{}, 0, err
+	}
+	return lastLock.Add(l.lockDuration(lockCount)), lockCount, nil
+}
+
+// failed reports a store error, the caller fails open
+func (l *AccountLockout) failed(key string, err error) {
+	if l.onError != nil {
+		l.onError(key, err)
+	}
+}
+
+// lockWindow is how long a lock counts as in a row with the next one: the
+// longest lock, then forgetAfter once it ended
+func (l *AccountLockout) lockWindow() time.Duration {
+	return l.maxDuration + l.forgetAfter
+}
+
+// lockDuration doubles the lock for every lock in a row
+func (l *AccountLockout) lockDuration(lockCount int) time.Duration {
+	duration := l.baseDuration
+	for i := 1; i < lockCount; i++ {
+		duration *= 2
+		if duration >= l.maxDuration {
+			return l.maxDuration
+		}
+	}
+	return duration
+}
+
+// lockoutKey normalizes emails so case changes do not bypass the lockout
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
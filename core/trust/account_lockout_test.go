@@ -0,0 +1,105 @@
+package trust
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountLockoutBackoff(t *testing.T) {
+	tests := []struct {
+		lockCount int
+		want      time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{10, 512 * time.Minute},
+		{11, 1024 * time.Minute},
+		{12, 24 * time.Hour},
+		{50, 24 * time.Hour},
+	}
+
+	lockout := NewAccountLockout(5, time.Minute, 24*time.Hour, 24*time.Hour)
+	for _, tt := range tests {
+		if got := lockout.lockDuration(tt.lockCount); got != tt.want {
+			t.Errorf("lockDuration(%d) = %v, want %v", tt.lockCount, got, tt.want)
+		}
+	}
+}
+
+func TestAccountLockoutLocksAfterThreshold(t *testing.T) {
+	lockout := NewAccountLockout(3, time.Minute, time.Hour, time.Hour)
+
+	var notified int
+	lockout.SetNotifier(func(key string, until time.Time, lockCount int) {
+		if key != "user@example.com" {
+			t.Errorf("notified key %q, want the normalized email", key)
+		}
+		notified = lockCount
+	})
+
+	for i := 0; i < 2; i++ {
+		if until := lockout.RecordFailure("User@Example.com "); !until.IsZero() {
+			t.Fatalf("locked after %d failures", i+1)
+		}
+	}
+
+	until := lockout.RecordFailure("user@example.com")
+	if until.IsZero() || notified != 1 {
+		t.Fatalf("not locked after the threshold (until %v, lock count %d)", until, notified)
+	}
+
+	locked, remaining := lockout.IsLocked("USER@example.com")
+	if !locked || remaining > time.Minute {
+		t.Errorf("IsLocked = %v, %v, want locked for at most a minute", locked, remaining)
+	}
+
+	// Failures while locked neither extend the lock nor count towards the next
+	if again := lockout.RecordFailure("user@example.com"); !again.Equal(until) {
+		t.Errorf("failure while locked moved the lock to %v", again)
+	}
+
+	locks := lockout.LockedAccounts()
+	if len(locks) != 1 || locks[0].Key != "user@example.com" || locks[0].LockCount != 1 {
+		t.Errorf("LockedAccounts = %+v", locks)
+	}
+
+	if !lockout.Unlock("user@example.com") {
+		t.Error("Unlock reported the account was not locked")
+	}
+	if locked, _ := lockout.IsLocked("user@example.com"); locked {
+		t.Error("still locked after Unlock")
+	}
+}
+
+func TestAccountLockoutDoublesLocksInARow(t *testing.T) {
+	lockout := NewAccountLockout(1, time.Minute, time.Hour, time.Hour)
+	store := NewMemoryFailureStore()
+	lockout.SetStore(store)
+
+	// A lock that already ended, recorded by another replica
+	store.Add(lockoutLocksPrefix+"user@example.com", time.Now().Add(-10*time.Minute), lockout.lockWindow())
+
+	until := lockout.RecordFailure("user@example.com")
+	if remaining := time.Until(until); remaining < 110*time.Second || remaining > 2*time.Minute {
+		t.Errorf("second lock lasts %v, want 2 minutes", remaining)
+	}
+}
+
+func TestAccountLockoutFailsOpen(t *testing.T) {
+	lockout := NewAccountLockout(1, time.Minute, time.Hour, time.Hour)
+	lockout.SetStore(brokenStore{})
+
+	var reported int
+	lockout.SetErrorHandler(func(key string, err error) { reported++ })
+
+	if until := lockout.RecordFailure("user@example.com"); !until.IsZero() {
+		t.Error("locked with a broken store")
+	}
+	if locked, _ := lockout.IsLocked("user@example.com"); locked {
+		t.Error("locked with a broken store")
+	}
+	if reported != 2 {
+		t.Errorf("reported %d errors, want 2", reported)
+	}
+}
@@ -0,0 +1,157 @@
+package trust
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultGeoIPCacheSize   = 10000
+	DefaultGeoIPCacheTTL    = time.Hour
+	DefaultGeoIPNegativeTTL = time.Minute // Failures are retried sooner, they are often rate limits
+	geoIPLookupTimeout      = 2 * time.Second
+)
+
+// CacheStats reports how a CachingResolver is doing
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// CachingResolver is a GeoIPResolver decorator keeping the latest lookups
+// in a bounded LRU. Failed lookups are cached for a shorter time, and
+// concurrent lookups of the same IP share a single call to the resolver.
+type CachingResolver struct {
+	next        GeoIPResolver
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+	group   singleflight.Group
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type geoIPCacheEntry struct {
+	ip     string
+	info   GeoIPInfo
+	status int
+	err    error
+	expiry time.Time
+}
+
+type geoIPLookup struct {
+	info   GeoIPInfo
+	status int
+}
+
+// NewCachingResolver wraps next with a cache of capacity IPs
+func NewCachingResolver(next GeoIPResolver, capacity int, ttl, negativeTTL time.Duration) *CachingResolver {
+	return &CachingResolver{
+		next:        next,
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// Resolve implements GeoIPResolver for CachingResolver
+func (c *CachingResolver) Resolve(ctx context.Context, ip string) (GeoIPInfo, int, error) {
+	if entry, found := c.get(ip); found {
+		c.hits.Add(1)
+		return entry.info, entry.status, entry.err
+	}
+	c.misses.Add(1)
+
+	// The shared lookup must not fail for every waiter when the caller that
+	// started it gives up, so it runs on its own deadline
+	results := c.group.DoChan(ip, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), geoIPLookupTimeout)
+		defer cancel()
+
+		info, status, err := c.next.Resolve(lookupCtx, ip)
+		c.put(ip, info, status, err)
+		return geoIPLookup{info: info, status: status}, err
+	})
+
+	select {
+	case result := <-results:
+		lookup := result.Val.(geoIPLookup)
+		return lookup.info, lookup.status, result.Err
+	case <-ctx.Done():
+		return GeoIPInfo{}, 0, ctx.Err()
+	}
+}
+
+// Stats returns the hit and miss counts since the start
+func (c *CachingResolver) Stats() CacheStats {
+	c.lock.Lock()
+	entries := c.order.Len()
+	c.lock.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// get returns a live entry and marks it as recently used
+func (c *CachingResolver) get(ip string) (*geoIPCacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, exists := c.entries[ip]
+	if !exists {
+		return nil, false
+	}
+
+	entry := element.Value.(*geoIPCacheEntry)
+	if time.Now().After(entry.expiry) {
+		c.order.Remove(element)
+		delete(c.entries, ip)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry, true
+}
+
+// put stores a lookup, evicting the least recently used IP when full
+func (c *CachingResolver) put(ip string, info GeoIPInfo, status int, err error) {
+	ttl := c.ttl
+	if err != nil {
+		ttl = c.negativeTTL
+	}
+
+	entry := &geoIPCacheEntry{ip: ip, info: info, status: status, err: err, expiry: time.Now().Add(ttl)}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, exists := c.entries[ip]; exists {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[ip] = c.order.PushFront(entry)
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*geoIPCacheEntry).ip)
+	}
+}
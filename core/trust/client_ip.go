@@ -0,0 +1,125 @@
+package trust
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks whose forwarding headers are believed;
+// main loads them from TRUSTED_PROXIES. With none, the client IP is always
+// the direct peer.
+var TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or single IPs
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// GetIPFromRequest extracts client IP from HTTP request. Forwarding headers
+// are only honoured when the direct peer is a trusted proxy, and
+// X-Forwarded-For is walked from the right: the first address that is not a
+// trusted proxy is the client, whatever the client put on the left.
+func GetIPFromRequest(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+
+	if forwarded := forwardedFor(r); len(forwarded) > 0 {
+		client := peer
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			ip := parseForwardedIP(forwarded[i])
+			if ip == "" {
+				// Garbage in the chain, nothing left of it can be believed
+				return client
+			}
+			client = ip
+			if !isTrustedProxy(ip) {
+				return client
+			}
+		}
+		return client
+	}
+
+	if realIP := parseForwardedIP(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	if cfIP := parseForwardedIP(r.Header.Get("CF-Connecting-IP")); cfIP != "" {
+		return cfIP
+	}
+	return peer
+}
+
+// forwardedFor returns every X-Forwarded-For entry, across repeated headers
+func forwardedFor(r *http.Request) []string {
+	entries := []string{}
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(header, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// parseForwardedIP normalizes a header value to an IP, accepting a port
+// ("1.2.3.4:5678", "[::1]:5678"); it returns "" when it is not an IP
+func parseForwardedIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	ip := net.ParseIP(strings.Trim(value, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func isTrustedProxy(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
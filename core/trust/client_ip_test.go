@@ -0,0 +1,102 @@
+package trust
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetIPFromRequest(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	previous := TrustedProxies
+	TrustedProxies = proxies
+	defer func() { TrustedProxies = previous }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string // One entry per X-Forwarded-For header
+		realIP     string
+		want       string
+	}{
+		{
+			name:       "untrusted peer is the client whatever it claims",
+			remoteAddr: "203.0.113.9:4000",
+			xff:        []string{"198.51.100.1"},
+			realIP:     "198.51.100.2",
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:4000",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "single hop",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "spoofed left entries are ignored",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"1.1.1.1, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "trusted proxies in the chain are skipped",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"1.1.1.1, 198.51.100.1, 192.168.1.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "entries across repeated headers",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"1.1.1.1", "198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "garbage stops the walk at the last believable hop",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"198.51.100.1, not-an-ip, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "only trusted proxies gives the leftmost",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"10.0.0.3, 10.0.0.2"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "entries with ports",
+			remoteAddr: "10.0.0.1:4000",
+			xff:        []string{"[2001:db8::1]:443, 10.0.0.2:80"},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "X-Real-IP from a trusted peer",
+			remoteAddr: "10.0.0.1:4000",
+			realIP:     "198.51.100.7",
+			want:       "198.51.100.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.xff {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := GetIPFromRequest(r); got != tt.want {
+				t.Errorf("GetIPFromRequest = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
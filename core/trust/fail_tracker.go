@@ -1,50 +1,59 @@
 package trust
 
 import (
-	"sync"
 	"time"
 )
 
+// FailedLoginTracker counts failed attempts per key within a sliding window.
+// Store errors fail open: an outage of a shared store must not lock every
+// user out.
 type FailedLoginTracker struct {
-	attempts   map[string][]time.Time
-	lock       sync.RWMutex
-	expiry     time.Duration
-	threshold  int
-	penalty    int
+	store     FailureStore
+	expiry    time.Duration
+	threshold int
+	penalty   int
+	onError   StoreErrorHandler
 }
 
-// NewFailedLoginTracker initializes the tracker
+// NewFailedLoginTracker initializes the tracker with the in-memory store
 func NewFailedLoginTracker(threshold int, expiry time.Duration, penalty int) *FailedLoginTracker {
+	return NewFailedLoginTrackerWithStore(NewMemoryFailureStore(), threshold, expiry, penalty)
+}
+
+// NewFailedLoginTrackerWithStore initializes the tracker on top of a store
+func NewFailedLoginTrackerWithStore(store FailureStore, threshold int, expiry time.Duration, penalty int) *FailedLoginTracker {
 	return &FailedLoginTracker{
-		attempts:  make(map[string][]time.Time),
+		store:     store,
 		expiry:    expiry,
 		threshold: threshold,
 		penalty:   penalty,
 	}
 }
 
-// RecordFailure logs a failed attempt for an IP or user ID
-func (t *FailedLoginTracker) RecordFailure(key string) int {
-	t.lock.Lock()
-	defer t.lock.Unlock()
+// SetStore replaces the store, main calls it before serving requests
+func (t *FailedLoginTracker) SetStore(store FailureStore) {
+	t.store = store
+}
 
-	now := time.Now()
-	t.cleanupOldAttempts(key, now)
+// SetErrorHandler registers the function told about store errors, main
+// calls it before serving requests
+func (t *FailedLoginTracker) SetErrorHandler(handler StoreErrorHandler) {
+	t.onError = handler
+}
 
-	t.attempts[key] = append(t.attempts[key], now)
-	return len(t.attempts[key]) // Return current count
+// RecordFailure logs a failed attempt for an IP or user ID
+func (t *FailedLoginTracker) RecordFailure(key string) int {
+	count, err := t.store.Add(key, time.Now(), t.expiry)
+	if err != nil {
+		t.failed(key, err)
+		return 0
+	}
+	return count // Return current count
 }
 
 // ShouldPenalize checks if penalty should be applied
 func (t *FailedLoginTracker) ShouldPenalize(key string) (bool, int) {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
-
-	now := time.Now()
-	t.cleanupOldAttempts(key, now)
-
-	currentCount := len(t.attempts[key])
-	if currentCount >= t.threshold {
+	if t.GetFailureCount(key) >= t.threshold {
 		return true, t.penalty
 	}
 	return false, 0
@@ -52,55 +61,40 @@ func (t *FailedLoginTracker) ShouldPenalize(key string) (bool, int) {
 
 // GetFailureCount returns current failure count for a key
 func (t *FailedLoginTracker) GetFailureCount(key string) int {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
-
-	t.cleanupOldAttempts(key, time.Now())
-	return len(t.attempts[key])
+	count, err := t.store.Count(key, time.Now().Add(-t.expiry))
+	if err != nil {
+		t.failed(key, err)
+		return 0
+	}
+	return count
 }
 
 // ResetFailures clears the failed attempts for a key
 func (t *FailedLoginTracker) ResetFailures(key string) {
-	t.lock.Lock()
-	defer t.lock.Unlock()
-	delete(t.attempts, key)
+	if err := t.store.Reset(key); err != nil {
+		t.failed(key, err)
+	}
 }
 
 // GetTimeUntilReset returns duration until oldest failure expires
 func (t *FailedLoginTracker) GetTimeUntilReset(key string) time.Duration {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
-
 	now := time.Now()
-	attempts := t.attempts[key]
-	if len(attempts) == 0 {
+
+	oldest, err := t.store.Oldest(key, now.Add(-t.expiry))
+	if err != nil {
+		t.failed(key, err)
 		return 0
 	}
-
-	// Find oldest attempt
-	oldest := attempts[0]
-	for _, ts := range attempts {
-		if ts.Before(oldest) {
-			oldest = ts
-		}
+	if oldest.IsZero() {
+		return 0
 	}
 
-	expiryTime := oldest.Add(t.expiry)
-	return expiryTime.Sub(now)
+	return oldest.Add(t.expiry).Sub(now)
 }
 
-// cleanupOldAttempts removes expired attempts
-func (t *FailedLoginTracker) cleanupOldAttempts(key string, now time.Time) {
-	attempts, exists := t.attempts[key]
-	if !exists {
-		return
+// failed reports a store error, the caller fails open
+func (t *FailedLoginTracker) failed(key string, err error) {
+	if t.onError != nil {
+		t.onError(key, err)
 	}
-
-	filtered := attempts[:0]
-	for _, ts := range attempts {
-		if now.Sub(ts) <= t.expiry {
-			filtered = append(filtered, ts)
-		}
-	}
-	t.attempts[key] = filtered
-}
\ No newline at end of file
+}
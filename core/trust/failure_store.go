@@ -0,0 +1,141 @@
+package trust
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailureStore keeps the timestamps of failed attempts per key (an IP, a
+// prefixed user ID or a prefixed email). Every implementation uses a sliding window: a failure
+// counts until it is older than the window passed to Add.
+type FailureStore interface {
+	Add(key string, at time.Time, window time.Duration) (int, error) // Record a failure, returns the failures within the window
+	Count(key string, since time.Time) (int, error)                  // Count the failures after since
+	Oldest(key string, since time.Time) (time.Time, error)           // First failure after since, zero if none
+	Newest(key string, since time.Time) (time.Time, error)           // Last failure after since, zero if none
+	Keys(prefix string, since time.Time) ([]string, error)           // Keys starting with prefix with failures after since
+	Reset(key string) error                                          // Forget every failure of a key
+}
+
+// StoreErrorHandler is told about the failure store errors that the
+// trackers absorb by failing open, so an outage shows up in the logs.
+type StoreErrorHandler func(key string, err error)
+
+// MemoryFailureStore keeps failures in the process. Counts are lost on
+// restart and not shared between replicas.
+type MemoryFailureStore struct {
+	attempts map[string][]time.Time
+	lock     sync.Mutex
+}
+
+// NewMemoryFailureStore initializes the in-memory store
+func NewMemoryFailureStore() *MemoryFailureStore {
+	return &MemoryFailureStore{attempts: make(map[string][]time.Time)}
+}
+
+// Add records a failure and drops the ones that left the window
+func (s *MemoryFailureStore) Add(key string, at time.Time, window time.Duration) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	attempts := s.after(key, at.Add(-window))
+	s.attempts[key] = append(attempts, at)
+	return len(s.attempts[key]), nil
+}
+
+// Count returns the failures after since
+func (s *MemoryFailureStore) Count(key string, since time.Time) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.after(key, since)), nil
+}
+
+// Oldest returns the first failure after since
+func (s *MemoryFailureStore) Oldest(key string, since time.Time) (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	attempts := s.after(key, since)
+	if len(attempts) == 0 {
+		return time.Time{}, nil
+	}
+	return attempts[0], nil
+}
+
+// Newest returns the last failure after since
+func (s *MemoryFailureStore) Newest(key string, since time.Time) (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	attempts := s.after(key, since)
+	if len(attempts) == 0 {
+		return time.Time{}, nil
+	}
+	return attempts[len(attempts)-1], nil
+}
+
+// Keys lists the keys starting with prefix with failures after since
+func (s *MemoryFailureStore) Keys(prefix string, since time.Time) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := []string{}
+	for key := range s.attempts {
+		if strings.HasPrefix(key, prefix) && len(s.after(key, since)) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Reset clears the failures of a key
+func (s *MemoryFailureStore) Reset(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// after removes the failures up to since and returns the rest, oldest first
+func (s *MemoryFailureStore) after(key string, since time.Time) []time.Time {
+	attempts, exists := s.attempts[key]
+	if !exists {
+		return nil
+	}
+
+	filtered := attempts[:0]
+	for _, ts := range attempts {
+		if ts.After(since) {
+			filtered = append(filtered, ts)
+		}
+	}
+
+	if len(filtered) == 0 {
+		delete(s.attempts, key)
+		return nil
+	}
+	s.attempts[key] = filtered
+	return filtered
+}
+
+// NewFailureStoreFromEnv selects the store with FAILURE_STORE: "memory" (the
+// default), "mysql" using the application database, or "redis" using
+// REDIS_URL.
+func NewFailureStoreFromEnv(db *sql.DB) (FailureStore, error) {
+	switch driver := os.Getenv("FAILURE_STORE"); driver {
+	case "", "memory":
+		return NewMemoryFailureStore(), nil
+	case "mysql":
+		return NewMySQLFailureStore(db), nil
+	case "redis":
+		return NewRedisFailureStoreFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown FAILURE_STORE %q", driver)
+	}
+}
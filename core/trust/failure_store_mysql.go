@@ -0,0 +1,113 @@
+package trust
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MySQLFailureStore shares failures between replicas through the
+// failed_attempts table.
+type MySQLFailureStore struct {
+	DB        *sql.DB
+	lock      sync.Mutex
+	lastPrune time.Time
+}
+
+// NewMySQLFailureStore creates a new MySQLFailureStore.
+func NewMySQLFailureStore(db *sql.DB) *MySQLFailureStore {
+	return &MySQLFailureStore{DB: db}
+}
+
+// Add records a failure and returns the failures within the window
+func (s *MySQLFailureStore) Add(key string, at time.Time, window time.Duration) (int, error) {
+	_, err := s.DB.Exec(`INSERT INTO failed_attempts (failure_key, failed_at, expires_at) VALUES (?, ?, ?)`,
+		key, at, at.Add(window))
+	if err != nil {
+		return 0, fmt.Errorf("failed to record failed attempt: %v", err)
+	}
+
+	s.prune(at)
+
+	return s.Count(key, at.Add(-window))
+}
+
+// Count returns the failures after since
+func (s *MySQLFailureStore) Count(key string, since time.Time) (int, error) {
+	var count int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM failed_attempts WHERE failure_key = ? AND failed_at > ?`, key, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count failed attempts: %v", err)
+	}
+	return count, nil
+}
+
+// Oldest returns the first failure after since
+func (s *MySQLFailureStore) Oldest(key string, since time.Time) (time.Time, error) {
+	var oldest sql.NullTime
+	err := s.DB.QueryRow(`SELECT MIN(failed_at) FROM failed_attempts WHERE failure_key = ? AND failed_at > ?`, key, since).Scan(&oldest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to find oldest failed attempt: %v", err)
+	}
+	return oldest.Time, nil
+}
+
+// Newest returns the last failure after since
+func (s *MySQLFailureStore) Newest(key string, since time.Time) (time.Time, error) {
+	var newest sql.NullTime
+	err := s.DB.QueryRow(`SELECT MAX(failed_at) FROM failed_attempts WHERE failure_key = ? AND failed_at > ?`, key, since).Scan(&newest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to find newest failed attempt: %v", err)
+	}
+	return newest.Time, nil
+}
+
+// Keys lists the keys starting with prefix with failures after since
+func (s *MySQLFailureStore) Keys(prefix string, since time.Time) ([]string, error) {
+	rows, err := s.DB.Query(`SELECT DISTINCT failure_key FROM failed_attempts WHERE failure_key LIKE ? AND failed_at > ?`,
+		likePrefix(prefix), since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failure keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan failure key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Reset clears the failures of a key
+func (s *MySQLFailureStore) Reset(key string) error {
+	if _, err := s.DB.Exec(`DELETE FROM failed_attempts WHERE failure_key = ?`, key); err != nil {
+		return fmt.Errorf("failed to reset failed attempts: %v", err)
+	}
+	return nil
+}
+
+// prune deletes the failures that left their window, at most once a minute
+// per replica. Errors are ignored, the next prune catches up.
+func (s *MySQLFailureStore) prune(now time.Time) {
+	s.lock.Lock()
+	if time.Since(s.lastPrune) < time.Minute {
+		s.lock.Unlock()
+		return
+	}
+	s.lastPrune = time.Now()
+	s.lock.Unlock()
+
+	s.DB.Exec(`DELETE FROM failed_attempts WHERE expires_at <= ? LIMIT 1000`, now)
+}
+
+// likePrefix matches the keys starting with prefix, escaping the LIKE
+// wildcards it may contain
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
@@ -0,0 +1,196 @@
+package trust
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMemoryFailureStore(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		failures   []time.Duration // Offsets from base of the recorded failures
+		window     time.Duration
+		since      time.Duration
+		wantAdd    int // Count returned by the last Add
+		wantCount  int
+		wantOldest time.Duration
+		wantNewest time.Duration
+	}{
+		{
+			name:       "all within the window",
+			failures:   []time.Duration{0, time.Minute, 2 * time.Minute},
+			window:     10 * time.Minute,
+			since:      -time.Second,
+			wantAdd:    3,
+			wantCount:  3,
+			wantOldest: 0,
+			wantNewest: 2 * time.Minute,
+		},
+		{
+			name:       "old failures leave the window",
+			failures:   []time.Duration{0, 5 * time.Minute, 11 * time.Minute},
+			window:     10 * time.Minute,
+			since:      time.Minute,
+			wantAdd:    2,
+			wantCount:  2,
+			wantOldest: 5 * time.Minute,
+			wantNewest: 11 * time.Minute,
+		},
+		{
+			name:       "a failure at since is excluded",
+			failures:   []time.Duration{0, time.Minute},
+			window:     time.Hour,
+			since:      0,
+			wantAdd:    2,
+			wantCount:  1,
+			wantOldest: time.Minute,
+			wantNewest: time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryFailureStore()
+
+			var added int
+			for _, offset := range tt.failures {
+				var err error
+				added, err = store.Add("key", base.Add(offset), tt.window)
+				if err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+			}
+			if added != tt.wantAdd {
+				t.Errorf("Add returned %d, want %d", added, tt.wantAdd)
+			}
+
+			since := base.Add(tt.since)
+			if count, _ := store.Count("key", since); count != tt.wantCount {
+				t.Errorf("Count = %d, want %d", count, tt.wantCount)
+			}
+			if oldest, _ := store.Oldest("key", since); !oldest.Equal(base.Add(tt.wantOldest)) {
+				t.Errorf("Oldest = %v, want %v", oldest, base.Add(tt.wantOldest))
+			}
+			if newest, _ := store.Newest("key", since); !newest.Equal(base.Add(tt.wantNewest)) {
+				t.Errorf("Newest = %v, want %v", newest, base.Add(tt.wantNewest))
+			}
+		})
+	}
+}
+
+func TestMemoryFailureStoreKeysAndReset(t *testing.T) {
+	store := NewMemoryFailureStore()
+	now := time.Now()
+
+	store.Add("lockout:locks:a@example.com", now, time.Hour)
+	store.Add("lockout:locks:b@example.com", now.Add(-2*time.Hour), 3*time.Hour)
+	store.Add("10.0.0.1", now, time.Hour)
+
+	keys, err := store.Keys("lockout:locks:", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "lockout:locks:a@example.com" {
+		t.Errorf("Keys = %v, want only a@example.com", keys)
+	}
+
+	store.Reset("10.0.0.1")
+	if count, _ := store.Count("10.0.0.1", now.Add(-time.Hour)); count != 0 {
+		t.Errorf("Count after Reset = %d, want 0", count)
+	}
+}
+
+func TestMySQLFailureStoreAdd(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewMySQLFailureStore(db)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := 10 * time.Minute
+
+	// expires_at is NOT NULL, every insert must set it
+	mock.ExpectExec(`INSERT INTO failed_attempts \(failure_key, failed_at, expires_at\)`).
+		WithArgs("10.0.0.1", at, at.Add(window)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// Rows are pruned once their own window has passed, not a window later
+	mock.ExpectExec(`DELETE FROM failed_attempts WHERE expires_at <= \?`).
+		WithArgs(at).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM failed_attempts`).
+		WithArgs("10.0.0.1", at.Add(-window)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	count, err := store.Add("10.0.0.1", at, window)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Add returned %d, want 2", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLFailureStoreKeysEscapesPrefix(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	since := time.Now()
+	mock.ExpectQuery(`SELECT DISTINCT failure_key FROM failed_attempts WHERE failure_key LIKE \?`).
+		WithArgs(`a\_b\%%`, since).
+		WillReturnRows(sqlmock.NewRows([]string{"failure_key"}).AddRow("a_b%1"))
+
+	keys, err := NewMySQLFailureStore(db).Keys("a_b%", since)
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "a_b%1" {
+		t.Errorf("Keys = %v", keys)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// brokenStore fails every call, like an unreachable shared store
+type brokenStore struct{}
+
+var errStoreDown = errors.New("store down")
+
+func (brokenStore) Add(string, time.Time, time.Duration) (int, error) { return 0, errStoreDown }
+func (brokenStore) Count(string, time.Time) (int, error)              { return 0, errStoreDown }
+func (brokenStore) Oldest(string, time.Time) (time.Time, error)       { return time.Time{}, errStoreDown }
+func (brokenStore) Newest(string, time.Time) (time.Time, error)       { return time.Time{}, errStoreDown }
+func (brokenStore) Keys(string, time.Time) ([]string, error)          { return nil, errStoreDown }
+func (brokenStore) Reset(string) error                                { return errStoreDown }
+
+func TestFailedLoginTrackerReportsStoreErrors(t *testing.T) {
+	tracker := NewFailedLoginTrackerWithStore(brokenStore{}, 3, time.Minute, -50)
+
+	var reported []string
+	tracker.SetErrorHandler(func(key string, err error) {
+		reported = append(reported, key)
+	})
+
+	if count := tracker.RecordFailure("10.0.0.1"); count != 0 {
+		t.Errorf("RecordFailure = %d, want 0", count)
+	}
+	if penalized, _ := tracker.ShouldPenalize("10.0.0.1"); penalized {
+		t.Error("a broken store must fail open")
+	}
+	if len(reported) != 2 {
+		t.Errorf("reported %d errors, want 2", len(reported))
+	}
+}
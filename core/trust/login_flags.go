@@ -0,0 +1,53 @@
+package trust
+
+import (
+	"time"
+)
+
+// loginFlagPrefix keys the flags in the failure store, followed by the user ID
+const loginFlagPrefix = "flagged-login:"
+
+// LoginFlags remembers users who completed a suspicious login, such as an
+// impossible travel, without a second factor. The password may be in the
+// wrong hands, so RequireTrust asks every session of the user for a step-up
+// until the flag expires. Flags live in a FailureStore, shared between
+// replicas like the failed attempts, and store errors fail open.
+type LoginFlags struct {
+	store   FailureStore
+	ttl     time.Duration
+	onError StoreErrorHandler
+}
+
+// NewLoginFlags initializes the flags with the in-memory store
+func NewLoginFlags(ttl time.Duration) *LoginFlags {
+	return &LoginFlags{store: NewMemoryFailureStore(), ttl: ttl}
+}
+
+// SetStore replaces the store, main calls it before serving requests
+func (f *LoginFlags) SetStore(store FailureStore) {
+	f.store = store
+}
+
+// SetErrorHandler registers the function told about store errors, main
+// calls it before serving requests
+func (f *LoginFlags) SetErrorHandler(handler StoreErrorHandler) {
+	f.onError = handler
+}
+
+// Flag marks the user for ttl
+func (f *LoginFlags) Flag(userID string) error {
+	_, err := f.store.Add(loginFlagPrefix+userID, time.Now(), f.ttl)
+	return err
+}
+
+// IsFlagged reports whether the user completed a suspicious login within ttl
+func (f *LoginFlags) IsFlagged(userID string) bool {
+	count, err := f.store.Count(loginFlagPrefix+userID, time.Now().Add(-f.ttl))
+	if err != nil {
+		if f.onError != nil {
+			f.onError(loginFlagPrefix+userID, err)
+		}
+		return false
+	}
+	return count > 0
+}
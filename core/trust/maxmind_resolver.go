@@ -0,0 +1,144 @@
+package trust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maxmindReloadCheck is how often the database file is checked for changes
+const maxmindReloadCheck = 30 * time.Second
+
+var ErrIPNotFound = errors.New("IP address not found in the GeoIP database")
+
+// MaxMindResolver implements GeoIPResolver with a local MaxMind database
+// (GeoLite2-City, GeoIP2-City or any mmdb file with the same layout), so
+// client IPs never leave the server. The file is reloaded when it changes,
+// for example after geoipupdate ran.
+type MaxMindResolver struct {
+	path      string
+	lock      sync.RWMutex
+	reader    *maxminddb.Reader
+	modTime   time.Time
+	size      int64
+	lastCheck atomic.Int64 // Unix nanoseconds, read without the lock on every lookup
+}
+
+// maxmindRecord holds the fields read from a City database
+type maxmindRecord struct {
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		TimeZone  string  `maxminddb:"time_zone"`
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// NewMaxMindResolver opens the database at path
+func NewMaxMindResolver(path string) (*MaxMindResolver, error) {
+	r := &MaxMindResolver{path: path}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Resolve implements GeoIPResolver for MaxMindResolver. The status is
+// http.StatusOK when the IP was found and http.StatusNotFound otherwise,
+// mirroring the HTTP resolvers.
+func (r *MaxMindResolver) Resolve(ctx context.Context, ip string) (GeoIPInfo, int, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return GeoIPInfo{}, http.StatusBadRequest, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	r.reloadIfChanged()
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var record maxmindRecord
+	_, found, err := r.reader.LookupNetwork(parsed, &record)
+	if err != nil {
+		return GeoIPInfo{}, http.StatusInternalServerError, fmt.Errorf("GeoIP lookup failed: %v", err)
+	}
+	if !found {
+		return GeoIPInfo{}, http.StatusNotFound, ErrIPNotFound
+	}
+
+	return GeoIPInfo{
+		Country:   record.Country.Names["en"],
+		Timezone:  record.Location.TimeZone,
+		Latitude:  record.Location.Latitude,
+		Longitude: record.Location.Longitude,
+	}, http.StatusOK, nil
+}
+
+// Close releases the database
+func (r *MaxMindResolver) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.reader.Close()
+}
+
+// reloadIfChanged reopens the database when the file changed since it was
+// loaded. A broken new file is ignored and the current database kept.
+func (r *MaxMindResolver) reloadIfChanged() {
+	now := time.Now().UnixNano()
+	lastCheck := r.lastCheck.Load()
+	if now-lastCheck < int64(maxmindReloadCheck) {
+		return
+	}
+	// Only the lookup that wins the swap checks the file
+	if !r.lastCheck.CompareAndSwap(lastCheck, now) {
+		return
+	}
+
+	r.lock.RLock()
+	modTime, size := r.modTime, r.size
+	r.lock.RUnlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+		return
+	}
+
+	r.load()
+}
+
+// load opens the file and swaps it in, closing the previous database once
+// no lookup uses it anymore
+func (r *MaxMindResolver) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %v", err)
+	}
+
+	reader, err := maxminddb.Open(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %v", err)
+	}
+
+	r.lock.Lock()
+	previous := r.reader
+	r.reader = reader
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	r.lock.Unlock()
+	r.lastCheck.Store(time.Now().UnixNano())
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
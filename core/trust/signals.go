@@ -0,0 +1,239 @@
+package trust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPrivateIP       = errors.New("private or reserved IP")
+	ErrUnknownTimezone = errors.New("unknown timezone")
+	ErrInvalidTimezone = errors.New("invalid timezone")
+)
+
+// TrustSignal is one check contributing to the trust score. The engine adds
+// the weights of every registered signal to the maximum score.
+type TrustSignal interface {
+	Name() string                                           // Identifies the signal in the breakdown
+	Evaluate(ctx context.Context, in *SignalInput) Evidence // Weight and reason for a request
+}
+
+// Evidence is what a signal found about a request
+type Evidence struct {
+	Weight int    // Added to the score, negative for risk
+	Reason string // Empty when there is nothing to report
+}
+
+// SignalScore is the contribution of one signal, listed in TrustResult.Signals
+type SignalScore struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SignalInput is the request seen by the signals. The GeoIP lookup runs on
+// first use and is shared by every signal, so requests that no signal
+// locates never reach the resolver.
+type SignalInput struct {
+	IP        string
+	UserAgent string
+	Now       time.Time
+
+	resolver GeoIPResolver
+	once     sync.Once
+	looked   bool
+	info     GeoIPInfo
+	status   int
+	err      error
+}
+
+// GeoIP returns where the IP is, ErrPrivateIP for private and reserved IPs
+func (in *SignalInput) GeoIP(ctx context.Context) (GeoIPInfo, int, error) {
+	in.once.Do(func() {
+		in.looked = true
+		if isPrivateIP(in.IP) {
+			in.err = ErrPrivateIP
+			return
+		}
+		in.info, in.status, in.err = in.resolver.Resolve(ctx, in.IP)
+	})
+	return in.info, in.status, in.err
+}
+
+// LocalTime returns the time at the IP location. Country databases have no
+// timezone, they give ErrUnknownTimezone. GeoIP errors are passed through.
+func (in *SignalInput) LocalTime(ctx context.Context) (time.Time, error) {
+	info, _, err := in.GeoIP(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// LoadLocation reads "" as UTC
+	if info.Timezone == "" {
+		return time.Time{}, ErrUnknownTimezone
+	}
+
+	loc, err := time.LoadLocation(info.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidTimezone, info.Timezone)
+	}
+	return in.Now.In(loc), nil
+}
+
+// located returns where the IP is when a signal looked it up successfully,
+// without triggering a lookup
+func (in *SignalInput) located() (GeoIPInfo, bool) {
+	return in.info, in.looked && in.err == nil
+}
+
+// UserAgentSignal penalizes clients known for scanning or scripting
+type UserAgentSignal struct {
+	BadUserAgents        []string
+	SuspiciousUserAgents []string
+	BadPenalty           int
+	SuspiciousPenalty    int
+
+	cache    map[string]cachedUAPenalty
+	lock     sync.RWMutex
+	cacheTTL time.Duration
+}
+
+type cachedUAPenalty struct {
+	penalty int
+	expiry  time.Time
+}
+
+// NewUserAgentSignal creates the signal from the engine configuration
+func NewUserAgentSignal(config TrustEngineConfig) *UserAgentSignal {
+	return &UserAgentSignal{
+		BadUserAgents:        config.BadUserAgents,
+		SuspiciousUserAgents: config.SuspiciousUserAgents,
+		BadPenalty:           config.BadUAPenalty,
+		SuspiciousPenalty:    config.SuspiciousUAPenalty,
+		cache:                make(map[string]cachedUAPenalty),
+		cacheTTL:             1 * time.Hour, // Cache entries expire after 1 hour
+	}
+}
+
+// Name implements TrustSignal
+func (s *UserAgentSignal) Name() string {
+	return "user_agent"
+}
+
+// Evaluate implements TrustSignal
+func (s *UserAgentSignal) Evaluate(ctx context.Context, in *SignalInput) Evidence {
+	if penalty := s.penalty(in.UserAgent); penalty != 0 {
+		return Evidence{Weight: penalty, Reason: "User-Agent penalty applied"}
+	}
+	return Evidence{}
+}
+
+// penalty checks user agent and returns penalty score
+func (s *UserAgentSignal) penalty(userAgent string) int {
+	// Check cache first
+	s.lock.RLock()
+	if cached, exists := s.cache[userAgent]; exists {
+		if time.Now().Before(cached.expiry) {
+			s.lock.RUnlock()
+			return cached.penalty
+		}
+	}
+	s.lock.RUnlock()
+
+	uaLower := strings.ToLower(userAgent)
+	penalty := 0
+
+	// Check bad UAs
+	for _, bad := range s.BadUserAgents {
+		if strings.Contains(uaLower, strings.ToLower(bad)) {
+			penalty = s.BadPenalty
+			break
+		}
+	}
+
+	// Check suspicious UAs if no bad match
+	if penalty == 0 {
+		for _, sus := range s.SuspiciousUserAgents {
+			if strings.Contains(uaLower, strings.ToLower(sus)) {
+				penalty = s.SuspiciousPenalty
+				break
+			}
+		}
+	}
+
+	// Update cache
+	s.lock.Lock()
+	s.cache[userAgent] = cachedUAPenalty{
+		penalty: penalty,
+		expiry:  time.Now().Add(s.cacheTTL),
+	}
+	s.lock.Unlock()
+
+	return penalty
+}
+
+// GeoIPSignal locates the IP and reports why it could not. It weighs
+// nothing on its own, a failed lookup must not lock users out.
+type GeoIPSignal struct{}
+
+// Name implements TrustSignal
+func (GeoIPSignal) Name() string {
+	return "geoip"
+}
+
+// Evaluate implements TrustSignal
+func (GeoIPSignal) Evaluate(ctx context.Context, in *SignalInput) Evidence {
+	_, status, err := in.GeoIP(ctx)
+	switch {
+	case errors.Is(err, ErrPrivateIP):
+		return Evidence{Reason: "Private/reserved IP, skipping GeoIP check"}
+	case err != nil:
+		return Evidence{Reason: fmt.Sprintf("GeoIP error: %v (status: %d)", err, status)}
+	}
+	return Evidence{}
+}
+
+// AccessTimeSignal penalizes requests made in the middle of the night where
+// the IP is located
+type AccessTimeSignal struct {
+	StartHour int
+	EndHour   int
+	Penalty   int
+}
+
+// NewAccessTimeSignal creates the signal from the engine configuration
+func NewAccessTimeSignal(config TrustEngineConfig) *AccessTimeSignal {
+	return &AccessTimeSignal{
+		StartHour: config.AbnormalHourStart,
+		EndHour:   config.AbnormalHourEnd,
+		Penalty:   config.AbnormalHourPenalty,
+	}
+}
+
+// Name implements TrustSignal
+func (s *AccessTimeSignal) Name() string {
+	return "access_time"
+}
+
+// Evaluate implements TrustSignal
+func (s *AccessTimeSignal) Evaluate(ctx context.Context, in *SignalInput) Evidence {
+	local, err := in.LocalTime(ctx)
+	switch {
+	case errors.Is(err, ErrUnknownTimezone):
+		return Evidence{Reason: "Unknown timezone, skipping access time check"}
+	case errors.Is(err, ErrInvalidTimezone):
+		info, _, _ := in.GeoIP(ctx)
+		return Evidence{Reason: "Invalid timezone: " + info.Timezone}
+	case err != nil:
+		return Evidence{} // Located nowhere, the geoip signal tells why
+	}
+
+	if hour := local.Hour(); hour >= s.StartHour && hour <= s.EndHour {
+		return Evidence{Weight: s.Penalty, Reason: fmt.Sprintf("Abnormal access time: %02d:00 local", hour)}
+	}
+	return Evidence{}
+}
@@ -0,0 +1,190 @@
+package trust
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const earthRadiusKm = 6371.0
+
+// LoginLocation is where and when a user last logged in
+type LoginLocation struct {
+	IP         string    `json:"ip"`
+	Country    string    `json:"country"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	LoggedInAt time.Time `json:"logged_in_at"`
+}
+
+// LoginLocationStore remembers the last located login of each user
+type LoginLocationStore interface {
+	Last(userID string) (*LoginLocation, error)       // Last located login, nil if none
+	Save(userID string, location LoginLocation) error // Replace the last located login
+}
+
+// EvaluateLogin scores a login like CalculateTrustScore, then compares its
+// location with the previous login of the user. Logins that would need
+// travelling faster than MaxTravelSpeedKmh are penalized and flagged. The
+// location is not remembered, call RememberLogin once the login completed,
+// so a password alone cannot move the reference of an account with MFA.
+func (e *TrustEngine) EvaluateLogin(ctx context.Context, userID, ip, userAgent string) TrustResult {
+	result := e.CalculateTrustScore(ctx, ip, userAgent)
+	if !result.Located() {
+		return result
+	}
+
+	previous, err := e.locations.Last(userID)
+	if err != nil {
+		result.Reasons = append(result.Reasons, "Previous login location unavailable")
+	} else if previous != nil {
+		e.applyTravelPenalty(&result, previous, loginLocation(result, time.Now()))
+	}
+
+	return result
+}
+
+// RememberLogin saves the location of a completed login, the next logins of
+// the user are compared with it
+func (e *TrustEngine) RememberLogin(userID string, result TrustResult) error {
+	if !result.Located() {
+		return nil
+	}
+	return e.locations.Save(userID, loginLocation(result, time.Now()))
+}
+
+func loginLocation(result TrustResult, at time.Time) LoginLocation {
+	return LoginLocation{
+		IP:         result.ClientIP,
+		Country:    result.Country,
+		Latitude:   result.Latitude,
+		Longitude:  result.Longitude,
+		LoggedInAt: at,
+	}
+}
+
+// applyTravelPenalty penalizes a login too far from the previous one for
+// the time in between. Distances within the GeoIP accuracy are ignored.
+func (e *TrustEngine) applyTravelPenalty(result *TrustResult, previous *LoginLocation, current LoginLocation) {
+	distance := distanceKm(previous.Latitude, previous.Longitude, current.Latitude, current.Longitude)
+	if distance < e.config.MinTravelDistanceKm {
+		return
+	}
+
+	hours := current.LoggedInAt.Sub(previous.LoggedInAt).Hours()
+	speed := math.Inf(1)
+	if hours > 0 {
+		speed = distance / hours
+	}
+	if speed <= e.config.MaxTravelSpeedKmh {
+		return
+	}
+
+	result.ImpossibleTravel = true
+	e.apply(result, SignalScore{
+		Name:   "impossible_travel",
+		Weight: e.config.ImpossibleTravelPenalty,
+		Reason: fmt.Sprintf("Impossible travel: %.0f km from %s in %s since the last login",
+			distance, describeLocation(previous), current.LoggedInAt.Sub(previous.LoggedInAt).Round(time.Minute)),
+	})
+}
+
+func describeLocation(location *LoginLocation) string {
+	if location.Country != "" {
+		return location.Country
+	}
+	return location.IP
+}
+
+// distanceKm returns the great-circle distance between two coordinates
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// MemoryLoginLocationStore keeps login locations in the process
+type MemoryLoginLocationStore struct {
+	locations map[string]LoginLocation
+	lock      sync.RWMutex
+}
+
+// NewMemoryLoginLocationStore initializes the in-memory store
+func NewMemoryLoginLocationStore() *MemoryLoginLocationStore {
+	return &MemoryLoginLocationStore{locations: make(map[string]LoginLocation)}
+}
+
+// Last returns the last located login of a user
+func (s *MemoryLoginLocationStore) Last(userID string) (*LoginLocation, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	location, exists := s.locations[userID]
+	if !exists {
+		return nil, nil
+	}
+	return &location, nil
+}
+
+// Save replaces the last located login of a user
+func (s *MemoryLoginLocationStore) Save(userID string, location LoginLocation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.locations[userID] = location
+	return nil
+}
+
+// MySQLLoginLocationStore keeps login locations in the user_login_locations table
+type MySQLLoginLocationStore struct {
+	DB *sql.DB
+}
+
+// NewMySQLLoginLocationStore creates a new MySQLLoginLocationStore.
+func NewMySQLLoginLocationStore(db *sql.DB) *MySQLLoginLocationStore {
+	return &MySQLLoginLocationStore{DB: db}
+}
+
+// Last returns the last located login of a user
+func (s *MySQLLoginLocationStore) Last(userID string) (*LoginLocation, error) {
+	query := `
+		SELECT ip, country, latitude, longitude, logged_in_at
+		FROM user_login_locations
+		WHERE user_id = ?
+	`
+
+	var location LoginLocation
+	err := s.DB.QueryRow(query, userID).Scan(&location.IP, &location.Country, &location.Latitude, &location.Longitude, &location.LoggedInAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Return nil if the user never logged in from a located IP
+		}
+		return nil, fmt.Errorf("failed to find login location: %v", err)
+	}
+	return &location, nil
+}
+
+// Save replaces the last located login of a user
+func (s *MySQLLoginLocationStore) Save(userID string, location LoginLocation) error {
+	query := `
+		INSERT INTO user_login_locations (user_id, ip, country, latitude, longitude, logged_in_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE ip = VALUES(ip), country = VALUES(country), latitude = VALUES(latitude),
+			longitude = VALUES(longitude), logged_in_at = VALUES(logged_in_at)
+	`
+
+	_, err := s.DB.Exec(query, userID, location.IP, location.Country, location.Latitude, location.Longitude, location.LoggedInAt)
+	if err != nil {
+		return fmt.Errorf("failed to save login location: %v", err)
+	}
+	return nil
+}
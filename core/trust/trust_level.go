@@ -0,0 +1,54 @@
+package trust
+
+// Decision is what a route does with a request after scoring it
+type Decision int
+
+const (
+	DecisionAllow  Decision = iota // Trusted enough, the request goes through
+	DecisionStepUp                 // The user must prove it is them again
+	DecisionDeny                   // Too risky, even after a step-up
+)
+
+// String returns the name used in logs and responses
+func (d Decision) String() string {
+	switch d {
+	case DecisionAllow:
+		return "allow"
+	case DecisionStepUp:
+		return "step_up"
+	default:
+		return "deny"
+	}
+}
+
+// TrustLevel is the minimum trust a route needs. Scores from AllowScore up
+// are allowed, scores from StepUpScore up need a step-up, lower ones are
+// denied.
+type TrustLevel struct {
+	Name         string
+	AllowScore   int
+	StepUpScore  int
+	SecondFactor bool // Users with two-factor authentication step up with a code, not the password
+}
+
+var (
+	// TrustLevelBasic only turns away clearly hostile requests, there is no
+	// step-up so it also works on routes without a logged in user
+	TrustLevelBasic = TrustLevel{Name: "basic", AllowScore: 30, StepUpScore: 30}
+	// TrustLevelStandard is for everyday actions on a user's own resources
+	TrustLevelStandard = TrustLevel{Name: "standard", AllowScore: 60, StepUpScore: 30}
+	// TrustLevelHigh is for destructive actions that cannot be undone
+	TrustLevelHigh = TrustLevel{Name: "high", AllowScore: 80, StepUpScore: 50, SecondFactor: true}
+)
+
+// Decide maps a trust score to the decision of the level
+func (l TrustLevel) Decide(score int) Decision {
+	switch {
+	case score >= l.AllowScore:
+		return DecisionAllow
+	case score >= l.StepUpScore:
+		return DecisionStepUp
+	default:
+		return DecisionDeny
+	}
+}
@@ -3,30 +3,46 @@ package trust
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"strings"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 )
 
 // TrustResult represents the result of trust evaluation
 type TrustResult struct {
-	Score     int      `json:"score"`
-	Reasons   []string `json:"reasons,omitempty"`
-	Country   string   `json:"country,omitempty"`
-	Timezone  string   `json:"timezone,omitempty"`
-	LocalHour int      `json:"local_hour,omitempty"`
-	ClientIP  string   `json:"client_ip"`
-	UserAgent string   `json:"user_agent"`
+	Score            int           `json:"score"`
+	Reasons          []string      `json:"reasons,omitempty"`
+	Signals          []SignalScore `json:"signals,omitempty"` // Contribution of every signal to the score
+	Country          string        `json:"country,omitempty"`
+	Timezone         string        `json:"timezone,omitempty"`
+	LocalHour        int           `json:"local_hour,omitempty"`
+	Latitude         float64       `json:"latitude,omitempty"`
+	Longitude        float64       `json:"longitude,omitempty"`
+	ClientIP         string        `json:"client_ip"`
+	UserAgent        string        `json:"user_agent"`
+	ImpossibleTravel bool          `json:"impossible_travel,omitempty"` // Set by EvaluateLogin
+
+	sum int // Weights added so far, Score is this sum clamped
+}
+
+// Located reports whether the GeoIP lookup gave coordinates. 0,0 is what
+// resolvers return when they only know the country.
+func (r TrustResult) Located() bool {
+	return r.Latitude != 0 || r.Longitude != 0
 }
 
 // GeoIPInfo holds geographical information from IP
 type GeoIPInfo struct {
-	Country  string `json:"country_name"`
-	Timezone string `json:"timezone"`
+	Country   string  `json:"country_name"`
+	Timezone  string  `json:"timezone"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
 // GeoIPResolver defines the interface for IP geolocation services
@@ -46,20 +62,20 @@ type TrustEngineConfig struct {
 	SuspiciousUAPenalty  int
 	AbnormalHourPenalty  int
 	GeoIPServiceURL      string
+	// Impossible travel between two logins of the same user
+	MaxTravelSpeedKmh       float64
+	MinTravelDistanceKm     float64 // Closer logins are within GeoIP accuracy
+	ImpossibleTravelPenalty int
+	UnknownDevicePenalty    int // Login from a device the user never used
 }
 
 // TrustEngine handles trust score calculations
 type TrustEngine struct {
-	config       TrustEngineConfig
-	resolver     GeoIPResolver
-	uaCheckCache map[string]cachedUAPenalty
-	cacheMutex   sync.RWMutex
-	cacheTTL     time.Duration
-}
-
-type cachedUAPenalty struct {
-	penalty int
-	expiry  time.Time
+	config    TrustEngineConfig
+	resolver  GeoIPResolver
+	locations LoginLocationStore
+	signals   []TrustSignal
+	lock      sync.RWMutex // Guards signals, replaced rather than modified
 }
 
 // IPAPIResolver implements GeoIPResolver using ipapi.co
@@ -85,38 +101,153 @@ func NewDefaultConfig() TrustEngineConfig {
 		SuspiciousUAPenalty: -30,  // Suspicious UA penalty
 		AbnormalHourPenalty: -40,  // Off-hours penalty
 		GeoIPServiceURL:     "https://ipapi.co/%s/json/",
+		// Faster than an airliner, logins this far apart need two people
+		MaxTravelSpeedKmh:       900,
+		MinTravelDistanceKm:     100,
+		ImpossibleTravelPenalty: -50,
+		UnknownDevicePenalty:    -20,
 	}
 }
 
+// NewConfigFromEnv creates the default configuration, with the impossible
+// travel speed read from IMPOSSIBLE_TRAVEL_SPEED_KMH when set
+func NewConfigFromEnv() (TrustEngineConfig, error) {
+	config := NewDefaultConfig()
+
+	if value := os.Getenv("IMPOSSIBLE_TRAVEL_SPEED_KMH"); value != "" {
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil || speed <= 0 {
+			return config, fmt.Errorf("invalid IMPOSSIBLE_TRAVEL_SPEED_KMH %q", value)
+		}
+		config.MaxTravelSpeedKmh = speed
+	}
+
+	return config, nil
+}
+
 // NewTrustEngine creates a new trust engine instance
 func NewTrustEngine(config TrustEngineConfig, resolver GeoIPResolver) *TrustEngine {
 	if resolver == nil {
-		resolver = &IPAPIResolver{
-			client: &http.Client{
-				Timeout: 2 * time.Second,
-				Transport: &http.Transport{
-					DisableKeepAlives: true,
-					MaxIdleConns:      10,
-				},
-			},
-			endpoint: config.GeoIPServiceURL,
-		}
+		resolver = NewIPAPIResolver(config.GeoIPServiceURL)
 	}
 
 	return &TrustEngine{
-		config:       config,
-		resolver:     resolver,
-		uaCheckCache: make(map[string]cachedUAPenalty),
-		cacheTTL:     1 * time.Hour, // Cache entries expire after 1 hour
+		config:    config,
+		resolver:  resolver,
+		locations: NewMemoryLoginLocationStore(),
+		signals:   DefaultSignals(config),
+	}
+}
+
+// DefaultSignals returns the built-in signals: the User-Agent lists, the
+// GeoIP lookup and the access time
+func DefaultSignals(config TrustEngineConfig) []TrustSignal {
+	return []TrustSignal{
+		NewUserAgentSignal(config),
+		GeoIPSignal{},
+		NewAccessTimeSignal(config),
 	}
 }
 
+// RegisterSignal adds a signal evaluated after the ones already registered.
+// It is safe while requests are scored, they use the signals registered when
+// they started.
+func (e *TrustEngine) RegisterSignal(signal TrustSignal) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	signals := make([]TrustSignal, 0, len(e.signals)+1)
+	e.signals = append(append(signals, e.signals...), signal)
+}
+
+// Signals returns the names of the registered signals in evaluation order
+func (e *TrustEngine) Signals() []string {
+	signals := e.registered()
+	names := make([]string, 0, len(signals))
+	for _, signal := range signals {
+		names = append(names, signal.Name())
+	}
+	return names
+}
+
+// registered returns the signals to evaluate, the slice is never modified
+func (e *TrustEngine) registered() []TrustSignal {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.signals
+}
+
+// SetLocationStore replaces where login locations are remembered, main
+// calls it before serving requests
+func (e *TrustEngine) SetLocationStore(store LoginLocationStore) {
+	e.locations = store
+}
+
+// Resolver returns the GeoIP resolver of the engine
+func (e *TrustEngine) Resolver() GeoIPResolver {
+	return e.resolver
+}
+
 // NewDefaultTrustEngine creates a trust engine with default configuration
 func NewDefaultTrustEngine() *TrustEngine {
 	config := NewDefaultConfig()
 	return NewTrustEngine(config, nil)
 }
 
+// NewIPAPIResolver creates a resolver calling endpoint, a format string
+// receiving the IP
+func NewIPAPIResolver(endpoint string) *IPAPIResolver {
+	return &IPAPIResolver{
+		client: &http.Client{
+			Timeout: 2 * time.Second,
+			Transport: &http.Transport{
+				DisableKeepAlives: true,
+				MaxIdleConns:      10,
+			},
+		},
+		endpoint: endpoint,
+	}
+}
+
+// NewGeoIPResolverFromEnv selects the resolver with GEOIP_RESOLVER: "ipapi"
+// (the default) calls ipapi.co, "maxmind" reads the mmdb file at
+// GEOIP_DB_PATH locally. Lookups are cached for GEOIP_CACHE_SIZE IPs
+// (DefaultGeoIPCacheSize by default, 0 disables the cache).
+func NewGeoIPResolverFromEnv() (GeoIPResolver, error) {
+	resolver, err := newGeoIPResolver(os.Getenv("GEOIP_RESOLVER"))
+	if err != nil {
+		return nil, err
+	}
+
+	size := DefaultGeoIPCacheSize
+	if value := os.Getenv("GEOIP_CACHE_SIZE"); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid GEOIP_CACHE_SIZE %q", value)
+		}
+	}
+	if size == 0 {
+		return resolver, nil
+	}
+
+	return NewCachingResolver(resolver, size, DefaultGeoIPCacheTTL, DefaultGeoIPNegativeTTL), nil
+}
+
+func newGeoIPResolver(resolver string) (GeoIPResolver, error) {
+	switch resolver {
+	case "", "ipapi":
+		return NewIPAPIResolver(NewDefaultConfig().GeoIPServiceURL), nil
+	case "maxmind":
+		path := os.Getenv("GEOIP_DB_PATH")
+		if path == "" {
+			return nil, errors.New("GEOIP_DB_PATH is required when GEOIP_RESOLVER is maxmind")
+		}
+		return NewMaxMindResolver(path)
+	default:
+		return nil, fmt.Errorf("unknown GEOIP_RESOLVER %q", resolver)
+	}
+}
+
 // Resolve implements GeoIPResolver for IPAPIResolver
 func (r *IPAPIResolver) Resolve(ctx context.Context, ip string) (GeoIPInfo, int, error) {
 	url := fmt.Sprintf(r.endpoint, ip)
@@ -148,99 +279,79 @@ func (r *IPAPIResolver) Resolve(ctx context.Context, ip string) (GeoIPInfo, int,
 	return info, resp.StatusCode, nil
 }
 
-// CalculateTrustScore computes a trust score for the given IP and User-Agent
+// CalculateTrustScore computes a trust score for the given IP and User-Agent.
+// Every signal adds its weight to the maximum score in registration order,
+// and the sum is clamped between MinScore and MaxScore. Once the sum is at
+// MinScore or below the remaining signals are skipped, so hostile clients do
+// not cost a GeoIP lookup.
 func (e *TrustEngine) CalculateTrustScore(ctx context.Context, ip, userAgent string) TrustResult {
+	return e.calculate(ctx, ip, userAgent, "")
+}
+
+// CalculateTokenTrustScore scores a request authenticated by a personal
+// access token. Those are meant for scripts, so the user agent signal is
+// skipped: a scripting client is expected, not a risk.
+func (e *TrustEngine) CalculateTokenTrustScore(ctx context.Context, ip, userAgent string) TrustResult {
+	return e.calculate(ctx, ip, userAgent, "user_agent")
+}
+
+// calculate runs every signal but skip
+func (e *TrustEngine) calculate(ctx context.Context, ip, userAgent, skip string) TrustResult {
 	result := TrustResult{
 		Score:     e.config.MaxScore,
 		ClientIP:  ip,
 		UserAgent: userAgent,
+		sum:       e.config.MaxScore,
 	}
 
-	// Check UA cache first
-	if penalty := e.getUAPenalty(userAgent); penalty != 0 {
-		result.Score += penalty
-		result.Reasons = append(result.Reasons, "User-Agent penalty applied")
-		if result.Score <= e.config.MinScore {
-			result.Score = e.config.MinScore
-			return result
-		}
-	}
+	input := &SignalInput{IP: ip, UserAgent: userAgent, Now: time.Now(), resolver: e.resolver}
 
-	if isPrivateIP(ip) {
-		result.Reasons = append(result.Reasons, "Private/reserved IP, skipping GeoIP check")
-	} else {
-		info, status, err := e.resolver.Resolve(ctx, ip)
-		if err != nil {
-			result.Reasons = append(result.Reasons, fmt.Sprintf("GeoIP error: %v (status: %d)", err, status))
-		} else {
-			result.Country = info.Country
-			result.Timezone = info.Timezone
-
-			if loc, err := time.LoadLocation(info.Timezone); err == nil {
-				result.LocalHour = time.Now().In(loc).Hour()
-				if result.LocalHour >= e.config.AbnormalHourStart && result.LocalHour <= e.config.AbnormalHourEnd {
-					result.Score += e.config.AbnormalHourPenalty
-					result.Reasons = append(result.Reasons,
-						fmt.Sprintf("Abnormal access time: %02d:00 local", result.LocalHour))
-				}
-			} else {
-				result.Reasons = append(result.Reasons, "Invalid timezone: "+info.Timezone)
-			}
+	for _, signal := range e.registered() {
+		if signal.Name() == skip {
+			continue
+		}
+		evidence := signal.Evaluate(ctx, input)
+		e.apply(&result, SignalScore{Name: signal.Name(), Weight: evidence.Weight, Reason: evidence.Reason})
+		if result.sum <= e.config.MinScore {
+			break
 		}
 	}
 
-	// Clamp final score
-	if result.Score < e.config.MinScore {
-		result.Score = e.config.MinScore
-	} else if result.Score > e.config.MaxScore {
-		result.Score = e.config.MaxScore
+	if info, located := input.located(); located {
+		result.Country = info.Country
+		result.Timezone = info.Timezone
+		result.Latitude = info.Latitude
+		result.Longitude = info.Longitude
+		if local, err := input.LocalTime(ctx); err == nil {
+			result.LocalHour = local.Hour()
+		}
 	}
 
 	return result
 }
 
-// getUAPenalty checks user agent and returns penalty score
-func (e *TrustEngine) getUAPenalty(userAgent string) int {
-	// Check cache first
-	e.cacheMutex.RLock()
-	if cached, exists := e.uaCheckCache[userAgent]; exists {
-		if time.Now().Before(cached.expiry) {
-			e.cacheMutex.RUnlock()
-			return cached.penalty
-		}
-	}
-	e.cacheMutex.RUnlock()
-
-	uaLower := strings.ToLower(userAgent)
-	penalty := 0
-
-	// Check bad UAs
-	for _, bad := range e.config.BadUserAgents {
-		if strings.Contains(uaLower, strings.ToLower(bad)) {
-			penalty = e.config.BadUAPenalty
-			break
-		}
-	}
+// PenalizeUnknownDevice lowers the score of a login from a device the user
+// never logged in from before
+func (e *TrustEngine) PenalizeUnknownDevice(result *TrustResult) {
+	e.apply(result, SignalScore{Name: "device", Weight: e.config.UnknownDevicePenalty, Reason: "Login from a new device"})
+}
 
-	// Check suspicious UAs if no bad match
-	if penalty == 0 {
-		for _, sus := range e.config.SuspiciousUserAgents {
-			if strings.Contains(uaLower, strings.ToLower(sus)) {
-				penalty = e.config.SuspiciousUAPenalty
-				break
-			}
-		}
+// apply adds the contribution of a signal to the result. The score is the
+// clamped sum of every weight, a bonus is not lost because an earlier
+// penalty went below MinScore.
+func (e *TrustEngine) apply(result *TrustResult, score SignalScore) {
+	result.sum += score.Weight
+	if score.Reason != "" {
+		result.Reasons = append(result.Reasons, score.Reason)
 	}
+	result.Signals = append(result.Signals, score)
 
-	// Update cache
-	e.cacheMutex.Lock()
-	e.uaCheckCache[userAgent] = cachedUAPenalty{
-		penalty: penalty,
-		expiry:  time.Now().Add(e.cacheTTL),
+	result.Score = result.sum
+	if result.Score < e.config.MinScore {
+		result.Score = e.config.MinScore
+	} else if result.Score > e.config.MaxScore {
+		result.Score = e.config.MaxScore
 	}
-	e.cacheMutex.Unlock()
-
-	return penalty
 }
 
 func isPrivateIP(ipStr string) bool {
@@ -267,24 +378,13 @@ func isPrivateIP(ipStr string) bool {
 	return false
 }
 
-// GetIPFromRequest extracts client IP from HTTP request
-func GetIPFromRequest(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
-	}
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-		return realIP
-	}
-	if cfIP := r.Header.Get("CF-Connecting-IP"); cfIP != "" {
-		return cfIP
-	}
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
-}
-
 var DefaultTrustEngine = NewDefaultTrustEngine()
 var FailedTracker = NewFailedLoginTracker(3, 10*time.Minute, -50)
+
+// AccountLocks locks an account after 5 failed logins for 1 minute, doubling
+// with every lock in a row up to a day. Accounts start over after a quiet day.
+var AccountLocks = NewAccountLockout(5, time.Minute, 24*time.Hour, 24*time.Hour)
+
+// FlaggedLogins makes RequireTrust ask for a step-up for a day after a
+// suspicious login without a second factor
+var FlaggedLogins = NewLoginFlags(24 * time.Hour)
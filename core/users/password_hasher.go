@@ -0,0 +1,283 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher turns passwords into encoded hashes and checks them.
+type PasswordHasher interface {
+	Hash(password string) (string, error)          // Encode a new password
+	Verify(encoded, password string) (bool, error) // Check a password against a hash in this hasher's format
+	NeedsRehash(encoded string) bool               // Whether a stored hash should be replaced by one from this hasher
+}
+
+// DefaultPasswordHasher hashes new passwords of every UserService; main
+// replaces it with the one configured by NewPasswordHasherFromEnv.
+var DefaultPasswordHasher PasswordHasher = NewArgon2idHasher(DefaultArgon2Params)
+
+// Argon2Params are the cost parameters of Argon2id.
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32 // Bytes
+	KeyLength   uint32 // Bytes
+}
+
+// DefaultArgon2Params follow the OWASP recommendation of 64 MiB and 3 passes.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// DefaultMaxConcurrentHashes bounds the Argon2id computations running at
+// once. Each one holds the configured memory, 64 MiB by default.
+const DefaultMaxConcurrentHashes = 4
+
+// argon2Slots is a semaphore shared by every Argon2idHasher, so a burst of
+// logins queues instead of exhausting memory
+var argon2Slots = make(chan struct{}, DefaultMaxConcurrentHashes)
+
+// SetMaxConcurrentHashes changes how many Argon2id computations run at once,
+// main calls it before serving requests
+func SetMaxConcurrentHashes(n int) {
+	argon2Slots = make(chan struct{}, n)
+}
+
+// argon2Key runs Argon2id once a slot is free
+func argon2Key(password, salt []byte, params Argon2Params) []byte {
+	slots := argon2Slots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	return argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+}
+
+// Argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+// NewArgon2idHasher creates an Argon2idHasher.
+func NewArgon2idHasher(params Argon2Params) *Argon2idHasher {
+	return &Argon2idHasher{Params: params}
+}
+
+// Hash hashes a password with a random salt.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	key := argon2Key([]byte(password), salt, h.Params)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks a password using the parameters stored in the hash, so
+// hashes made before a parameter change keep working.
+func (h *Argon2idHasher) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2Key([]byte(password), salt, params)
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+// NeedsRehash reports hashes of another algorithm or with other parameters.
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params != h.Params
+}
+
+// decodeArgon2id parses a PHC encoded Argon2id hash.
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %v", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 hash: %v", err)
+	}
+
+	if len(salt) == 0 || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// BcryptHasher produces the hashes used before Argon2id became the default.
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher creates a BcryptHasher.
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash hashes a password with bcrypt.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hashedPassword), nil
+}
+
+// Verify checks a password against a bcrypt hash.
+func (h *BcryptHasher) Verify(encoded, password string) (bool, error) {
+	if !isBcrypt(encoded) {
+		return false, ErrUnknownHashFormat
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to compare password: %v", err)
+	}
+	return true, nil
+}
+
+// NeedsRehash reports hashes of another algorithm or with another cost.
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	if !isBcrypt(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// VerifyPassword checks a password against a stored hash of any supported
+// format, whichever hasher is configured for new passwords.
+func VerifyPassword(encoded, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return (&Argon2idHasher{}).Verify(encoded, password)
+	case isBcrypt(encoded):
+		return (&BcryptHasher{}).Verify(encoded, password)
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+// NewPasswordHasherFromEnv selects the hasher with PASSWORD_HASHER:
+// "argon2id" (the default) tuned by ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and
+// ARGON2_PARALLELISM, or "bcrypt" tuned by BCRYPT_COST. ARGON2_MAX_CONCURRENCY
+// bounds the Argon2id hashes running at once, which also covers checking
+// existing Argon2id hashes when bcrypt is selected.
+func NewPasswordHasherFromEnv() (PasswordHasher, error) {
+	concurrency, err := envUint("ARGON2_MAX_CONCURRENCY", DefaultMaxConcurrentHashes, 16)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		return nil, errors.New("ARGON2_MAX_CONCURRENCY must be at least 1")
+	}
+	SetMaxConcurrentHashes(int(concurrency))
+
+	switch algorithm := os.Getenv("PASSWORD_HASHER"); algorithm {
+	case "", "argon2id":
+		params := DefaultArgon2Params
+
+		memory, err := envUint("ARGON2_MEMORY_KIB", uint64(params.Memory), 32)
+		if err != nil {
+			return nil, err
+		}
+		iterations, err := envUint("ARGON2_ITERATIONS", uint64(params.Iterations), 32)
+		if err != nil {
+			return nil, err
+		}
+		parallelism, err := envUint("ARGON2_PARALLELISM", uint64(params.Parallelism), 8)
+		if err != nil {
+			return nil, err
+		}
+
+		params.Memory = uint32(memory)
+		params.Iterations = uint32(iterations)
+		params.Parallelism = uint8(parallelism)
+
+		// Argon2 needs at least 8 KiB of memory per lane
+		if params.Iterations < 1 || params.Parallelism < 1 || params.Memory < 8*uint32(params.Parallelism) {
+			return nil, errors.New("invalid argon2 parameters")
+		}
+		return NewArgon2idHasher(params), nil
+	case "bcrypt":
+		cost, err := envUint("BCRYPT_COST", uint64(bcrypt.DefaultCost), 8)
+		if err != nil {
+			return nil, err
+		}
+		if int(cost) < bcrypt.MinCost || int(cost) > bcrypt.MaxCost {
+			return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return NewBcryptHasher(int(cost)), nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", algorithm)
+	}
+}
+
+// envUint reads an unsigned integer from the environment, or returns fallback when unset.
+func envUint(name string, fallback uint64, bits int) (uint64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,62 @@
+package users
+
+import "fmt"
+
+// Role decides what a user may do inside the namespace they belong to.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"     // Root user, full control over the namespace
+	RoleAdmin     Role = "admin"     // Everything the owner can do with containers, not manage users
+	RoleDeveloper Role = "developer" // Deploys and operates containers
+	RoleViewer    Role = "viewer"    // Read-only access
+)
+
+// Permission is a single action guarded by RBAC.
+type Permission string
+
+const (
+	PermContainersCreate  Permission = "containers:create"
+	PermContainersDelete  Permission = "containers:delete"
+	PermContainersOperate Permission = "containers:operate" // Stop, start and restart
+	PermContainersView    Permission = "containers:view"
+	PermMetricsView       Permission = "metrics:view"
+	PermUsersManage       Permission = "users:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermContainersCreate, PermContainersDelete, PermContainersOperate,
+		PermContainersView, PermMetricsView, PermUsersManage,
+	},
+	RoleAdmin: {
+		PermContainersCreate, PermContainersDelete, PermContainersOperate,
+		PermContainersView, PermMetricsView,
+	},
+	RoleDeveloper: {
+		PermContainersCreate, PermContainersDelete, PermContainersOperate,
+		PermContainersView, PermMetricsView,
+	},
+	RoleViewer: {
+		PermContainersView, PermMetricsView,
+	},
+}
+
+// ParseRole validates a role name.
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", name)
+	}
+	return role, nil
+}
+
+// Can reports whether the role grants a permission. Unknown roles grant nothing.
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,50 @@
 package users
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // User represents a user entity.
 type User struct {
-	ID         uuid.UUID `json:"id"`
-	Name       string    `json:"name"`
-	Email      string    `json:"email"`
-	Password   string    `json:"password,omitempty"`
-	RootUserID uuid.UUID `json:"root_user_id,omitempty"`
+	ID              uuid.UUID  `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Password        string     `json:"-"` // Hash, never serialized
+	RootUserID      uuid.UUID  `json:"root_user_id,omitempty"`
+	Role            Role       `json:"role"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"` // nil until the email was confirmed
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`       // Set while a disabled sub-user cannot log in
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`        // Set while the account waits for its teardown
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// EmailVerified reports whether the user confirmed their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Disabled reports whether the user was disabled by their root user.
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Active reports whether the user may log in: neither disabled nor deleted.
+func (u *User) Active() bool {
+	return u.DisabledAt == nil && u.DeletedAt == nil
+}
+
+// IsRoot reports whether the user is a root account rather than a sub-user.
+func (u *User) IsRoot() bool {
+	return u.RootUserID == uuid.Nil
+}
+
+// Namespace returns the namespace the user works in: their own for root
+// users, the root user's for sub-users.
+func (u *User) Namespace() string {
+	if u.IsRoot() {
+		return u.ID.String()
+	}
+	return u.RootUserID.String()
 }
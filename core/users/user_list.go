@@ -0,0 +1,71 @@
+package users
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserFilter selects users for a listing. Zero values do not filter.
+type UserFilter struct {
+	EmailPrefix   string
+	RootUserID    uuid.UUID
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Descending    bool    // Newest first instead of oldest first
+	After         *Cursor // Position of the last user of the previous page
+	Limit         int
+}
+
+// Cursor marks a position in a listing sorted by created_at. The ID breaks
+// ties between users created in the same second.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// UserPage is one page of a listing.
+type UserPage struct {
+	Users      []*User `json:"users"`
+	Count      int     `json:"count"`                 // Users matching the filter across all pages
+	NextCursor string  `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// Encode returns the opaque form of the cursor handed to clients.
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor returned by Encode.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.ID, err = uuid.Parse(id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
@@ -4,18 +4,39 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql" // Import the MySQL driver
+	"github.com/go-sql-driver/mysql" // Import the MySQL driver
 	"github.com/google/uuid"
 )
 
+// mysqlDuplicateEntry is the MySQL error number for unique index violations
+const mysqlDuplicateEntry = 1062
+
+// isDuplicateEntry reports whether err violates a unique index, on users
+// that is always the email
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry
+}
+
 // UserRepository defines methods for interacting with the data store.
 type UserRepository interface {
-	Save(user *User) (*User, error)          // Save a user to the database
-	SaveSubUser(user *User) (*User, error)   // Save a sub-user to the database
-	FindByID(id uuid.UUID) (*User, error)    // Retrieve a user by UUID
-	FindByEmail(email string) (*User, error) // Retrieve a user by email
-	FindAll() (int, error)                   // Retrieve all users
+	Save(user *User) (*User, error)                     // Save a user to the database
+	SaveSubUser(user *User) (*User, error)              // Save a sub-user to the database
+	FindSubUsers(rootUserID uuid.UUID) ([]*User, error) // Retrieve the sub-users of a root user
+	UpdateSubUser(user *User) error                     // Update the name and role of a sub-user
+	SetDisabledAt(id uuid.UUID, at *time.Time) error    // Disable a user, or enable it again with nil
+	SetDeletedAt(id uuid.UUID, at *time.Time) error     // Soft delete a user, or restore it with nil
+	Delete(id uuid.UUID) error                          // Remove a user
+	FindByID(id uuid.UUID) (*User, error)               // Retrieve a user by UUID
+	FindByEmail(email string) (*User, error)            // Retrieve a user by email
+	FindAll(filter UserFilter) ([]*User, error)         // Retrieve a page of users
+	Count(filter UserFilter) (int, error)               // Count the users matching a filter, ignoring paging
+	UpdatePassword(id uuid.UUID, hash string) error     // Replace the password hash of a user
+	UpdateProfile(user *User) error                     // Store the name, email and email verification of a user
+	MarkEmailVerified(id uuid.UUID, at time.Time) error // Record that the user confirmed their email
 }
 
 // MySQLUserRepository is the implementation of UserRepository using MySQL.
@@ -28,32 +49,26 @@ func NewMySQLUserRepository(db *sql.DB) *MySQLUserRepository {
 	return &MySQLUserRepository{DB: db}
 }
 
-// Save stores a user in the database.
+// Save stores a new user in the database. It never overwrites an existing
+// account: an email already in use gives ErrEmailTaken.
 func (repo *MySQLUserRepository) Save(user *User) (*User, error) {
 	// Generate a new UUID if the user doesn't have an ID
 	if user.ID == uuid.Nil {
 		user.ID = uuid.New()
 	}
 
-	// Prepare the query to insert or update the user
+	// Prepare the query to insert the user
 	query := `
-	INSERT INTO users (id, name, email, password)
-	VALUES (?, ?, ?, ?)
-	ON DUPLICATE KEY UPDATE 
-		name = VALUES(name), 
-		email = VALUES(email), 
-		password = VALUES(password)
-`
+		INSERT INTO users (id, name, email, password, role)
+		VALUES (?, ?, ?, ?, ?)
+	`
 
 	// Execute the query
-	_, err := repo.DB.Exec(
-		query,
-		// user.ID, user.Name, user.Email, user.Password, user.RootUserID,
-		user.ID, user.Name, user.Email, user.Password,
-		// user.Name, user.Email, user.Password, user.RootUserID,
-		// user.Name, user.Email, user.Password,
-	)
+	_, err := repo.DB.Exec(query, user.ID, user.Name, user.Email, user.Password, user.Role)
 	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, ErrEmailTaken
+		}
 		return nil, fmt.Errorf("failed to save user: %v", err)
 	}
 
@@ -69,25 +84,59 @@ func (repo *MySQLUserRepository) SaveSubUser(user *User) (*User, error) {
 
 	// Prepare the query to insert the sub-user
 	query := `
-		INSERT INTO users (id, name, email, password, root_user_id) 
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO users (id, name, email, password, root_user_id, role) 
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
 
 	// Execute the query
-	// _, err := repo.DB.Exec(query, user.ID, user.Name, user.Email, user.Password, user.RootUserID)
-	_, err := repo.DB.Exec(query, user.ID, user.Name, user.Email, user.Password)
+	_, err := repo.DB.Exec(query, user.ID, user.Name, user.Email, user.Password, user.RootUserID, user.Role)
 	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, ErrEmailTaken
+		}
 		return nil, fmt.Errorf("failed to save sub-user: %v", err)
 	}
 
 	return user, nil
 }
 
+// FindSubUsers retrieves the sub-users of a root user, oldest first.
+func (repo *MySQLUserRepository) FindSubUsers(rootUserID uuid.UUID) ([]*User, error) {
+	query := `
+		SELECT id, name, email, root_user_id, role, email_verified_at, disabled_at, deleted_at
+		FROM users
+		WHERE root_user_id = ?
+		ORDER BY created_at
+	`
+
+	rows, err := repo.DB.Query(query, rootUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sub-users: %v", err)
+	}
+	defer rows.Close()
+
+	subUsers := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.RootUserID, &user.Role, &user.EmailVerifiedAt, &user.DisabledAt, &user.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sub-user: %v", err)
+		}
+		subUsers = append(subUsers, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while reading rows: %v", err)
+	}
+
+	return subUsers, nil
+}
+
 // FindByID retrieves a user by ID from the database.
 func (repo *MySQLUserRepository) FindByID(id uuid.UUID) (*User, error) {
 	// Prepare the query to fetch the user by ID
 	query := `
-		SELECT id, name, email, root_user_id 
+		SELECT id, name, email, root_user_id, role, email_verified_at, disabled_at, deleted_at
 		FROM users 
 		WHERE id = ?
 	`
@@ -95,7 +144,7 @@ func (repo *MySQLUserRepository) FindByID(id uuid.UUID) (*User, error) {
 	// Execute the query and scan the result into a User struct
 	var user User
 	// err := repo.DB.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.RootUserID)
-	err := repo.DB.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.RootUserID)
+	err := repo.DB.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.RootUserID, &user.Role, &user.EmailVerifiedAt, &user.DisabledAt, &user.DeletedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("user not found")
@@ -110,7 +159,7 @@ func (repo *MySQLUserRepository) FindByID(id uuid.UUID) (*User, error) {
 func (repo *MySQLUserRepository) FindByEmail(email string) (*User, error) {
 	// Prepare the query to fetch the user by email
 	query := `
-		SELECT id, name, email, password
+		SELECT id, name, email, password, root_user_id, role, email_verified_at, disabled_at, deleted_at
 		FROM users 
 		WHERE email = ?
 	`
@@ -118,7 +167,7 @@ func (repo *MySQLUserRepository) FindByEmail(email string) (*User, error) {
 	// Execute the query and scan the result into a User struct
 	var user User
 	// err := repo.DB.QueryRow(query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.RootUserID)
-	err := repo.DB.QueryRow(query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password)
+	err := repo.DB.QueryRow(query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.RootUserID, &user.Role, &user.EmailVerifiedAt, &user.DisabledAt, &user.DeletedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil // Return nil if no user is found
@@ -129,45 +178,197 @@ func (repo *MySQLUserRepository) FindByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// FindAll retrieves count of users from the database.
-func (repo *MySQLUserRepository) FindAll() (int, error) {
-	// Prepare the query to fetch all users
+// FindAll retrieves a page of users sorted by creation time.
+func (repo *MySQLUserRepository) FindAll(filter UserFilter) ([]*User, error) {
+	where, args := filterConditions(filter, true)
+
+	order := "ASC"
+	if filter.Descending {
+		order = "DESC"
+	}
+
+	// Prepare the query to fetch the page, the password is never selected
 	query := `
-		SELECT count(*)
+		SELECT id, name, email, root_user_id, role, email_verified_at, disabled_at, deleted_at, created_at
 		FROM users
+		` + where + `
+		ORDER BY created_at ` + order + `, id ` + order + `
+		LIMIT ?
 	`
+	args = append(args, filter.Limit)
 
 	// Execute the query
-	rows, err := repo.DB.Query(query)
+	rows, err := repo.DB.Query(query, args...)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch users: %v", err)
+		return nil, fmt.Errorf("failed to fetch users: %v", err)
 	}
 	defer rows.Close()
 
-	var users int
-	rows.Next()
-	err = rows.Scan(&users)
+	// Loop through the rows and scan the data into User structs
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.RootUserID, &user.Role, &user.EmailVerifiedAt, &user.DisabledAt, &user.DeletedAt, &user.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, &user)
+	}
+
+	// Check for errors that occurred during row iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while reading rows: %v", err)
+	}
+
+	return users, nil
+}
+
+// Count returns the number of users matching a filter across all pages.
+func (repo *MySQLUserRepository) Count(filter UserFilter) (int, error) {
+	where, args := filterConditions(filter, false)
+
+	var count int
+	if err := repo.DB.QueryRow(`SELECT count(*) FROM users `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %v", err)
+	}
+
+	return count, nil
+}
+
+// filterConditions builds the WHERE clause of a listing. The cursor only
+// applies to pages, not to counts.
+func filterConditions(filter UserFilter, withCursor bool) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if filter.EmailPrefix != "" {
+		conditions = append(conditions, `email LIKE ? ESCAPE '\\'`)
+		args = append(args, likeEscaper.Replace(filter.EmailPrefix)+"%")
+	}
+	if filter.RootUserID != uuid.Nil {
+		conditions = append(conditions, `root_user_id = ?`)
+		args = append(args, filter.RootUserID)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, `created_at >= ?`)
+		args = append(args, filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, `created_at < ?`)
+		args = append(args, filter.CreatedBefore)
+	}
+	if withCursor && filter.After != nil {
+		comparison := ">"
+		if filter.Descending {
+			comparison = "<"
+		}
+		conditions = append(conditions, `(created_at `+comparison+` ? OR (created_at = ? AND id `+comparison+` ?))`)
+		args = append(args, filter.After.CreatedAt, filter.After.CreatedAt, filter.After.ID)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// UpdatePassword replaces the password hash of a user.
+func (repo *MySQLUserRepository) UpdatePassword(id uuid.UUID, hash string) error {
+	query := `
+		UPDATE users
+		SET password = ?
+		WHERE id = ?
+	`
 
+	result, err := repo.DB.Exec(query, hash, id)
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("failed to update password: %v", err)
 	}
 
-	// Loop through the rows and scan the data into User structs
-	// var users []*User
-	// for rows.Next() {
-	// 	var user User
-	// 	err := rows.Scan(&user.ID, &user.Name, &user.Email)
-	// 	// err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.RootUserID)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to scan user: %v", err)
-	// 	}
-	// 	users = append(users, &user)
-	// }
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user not found")
+	}
 
-	// Check for errors that occurred during row iteration
-	// if err := rows.Err(); err != nil {
-	// 	return nil, fmt.Errorf("error occurred while reading rows: %v", err)
-	// }
+	return nil
+}
 
-	return users, nil
+// MarkEmailVerified records when a user confirmed their email address.
+func (repo *MySQLUserRepository) MarkEmailVerified(id uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = ?
+		WHERE id = ? AND email_verified_at IS NULL
+	`
+
+	if _, err := repo.DB.Exec(query, at, id); err != nil {
+		return fmt.Errorf("failed to mark email as verified: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateSubUser stores the name and role of a sub-user.
+func (repo *MySQLUserRepository) UpdateSubUser(user *User) error {
+	query := `
+		UPDATE users
+		SET name = ?, role = ?
+		WHERE id = ? AND root_user_id = ?
+	`
+
+	if _, err := repo.DB.Exec(query, user.Name, user.Role, user.ID, user.RootUserID); err != nil {
+		return fmt.Errorf("failed to update sub-user: %v", err)
+	}
+
+	return nil
+}
+
+// SetDisabledAt disables a user, or enables it again when at is nil.
+func (repo *MySQLUserRepository) SetDisabledAt(id uuid.UUID, at *time.Time) error {
+	if _, err := repo.DB.Exec(`UPDATE users SET disabled_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to update user status: %v", err)
+	}
+	return nil
+}
+
+// SetDeletedAt soft deletes a user, or restores it when at is nil.
+func (repo *MySQLUserRepository) SetDeletedAt(id uuid.UUID, at *time.Time) error {
+	if _, err := repo.DB.Exec(`UPDATE users SET deleted_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to update user deletion: %v", err)
+	}
+	return nil
+}
+
+// Delete removes a user from the database.
+func (repo *MySQLUserRepository) Delete(id uuid.UUID) error {
+	if _, err := repo.DB.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+	return nil
+}
+
+// UpdateProfile stores the name and email of a user. The unique email index
+// rejects addresses that belong to another user.
+func (repo *MySQLUserRepository) UpdateProfile(user *User) error {
+	query := `
+		UPDATE users
+		SET name = ?, email = ?, email_verified_at = ?
+		WHERE id = ?
+	`
+
+	_, err := repo.DB.Exec(query, user.Name, user.Email, user.EmailVerifiedAt, user.ID)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("failed to update profile: %v", err)
+	}
+
+	return nil
 }
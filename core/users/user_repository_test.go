@@ -0,0 +1,48 @@
+package users
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLUserRepositorySaveNeverOverwrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		execErr error
+		wantErr error
+	}{
+		{name: "new email", wantErr: nil},
+		{name: "email in use", execErr: &mysql.MySQLError{Number: mysqlDuplicateEntry}, wantErr: ErrEmailTaken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			user := &User{Name: "Ada", Email: "ada@example.com", Password: "hash", Role: RoleOwner}
+
+			exec := mock.ExpectExec(`^\s*INSERT INTO users \(id, name, email, password, role\)\s+VALUES \(\?, \?, \?, \?, \?\)\s*$`).
+				WithArgs(sqlmock.AnyArg(), "Ada", "ada@example.com", "hash", RoleOwner)
+			if tt.execErr != nil {
+				exec.WillReturnError(tt.execErr)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			_, err = NewMySQLUserRepository(db).Save(user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Save error = %v, want %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
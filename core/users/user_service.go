@@ -3,35 +3,71 @@ package users
 import (
 	"errors"
 	"fmt" // import the fmt package
+	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrRootUserNotFound = errors.New("root user not found")
+	ErrSubUserNotFound  = errors.New("sub-user not found")
+	ErrInvalidRole      = errors.New("sub-users can be admin, developer or viewer")
+	ErrEmailTaken       = errors.New("email already in use")
 )
 
 // UserService contains business logic related to users.
 type UserService struct {
 	UserRepo UserRepository
+	Hasher   PasswordHasher // Hashes new passwords
 }
 
 // NewUserService creates and returns a new instance of UserService.
 func NewUserService(repo UserRepository) *UserService {
 	return &UserService{
 		UserRepo: repo,
+		Hasher:   DefaultPasswordHasher,
 	}
 }
 
-// GetAllUsers retrieves all users from the database.
-func (s *UserService) GetAllUsers() (int, error) {
-	return s.UserRepo.FindAll()
+// ListUsers retrieves a page of users and the number of users matching the filter.
+func (s *UserService) ListUsers(filter UserFilter) (*UserPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultListLimit
+	}
+	if filter.Limit > MaxListLimit {
+		filter.Limit = MaxListLimit
+	}
+	pageSize := filter.Limit
+
+	// One extra row tells whether another page follows
+	filter.Limit++
+	users, err := s.UserRepo.FindAll(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.UserRepo.Count(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UserPage{Users: users, Count: count}
+	if len(users) > pageSize {
+		page.Users = users[:pageSize]
+		last := page.Users[pageSize-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return page, nil
 }
 
 // CreateUser handles the creation of a new user.
 // Calls create namespace to create a new namespace for the user
 func (s *UserService) CreateUser(name, email, password string) (*User, error) {
 	// Hash the password before saving it
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.Hasher.Hash(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %v", err)
+		return nil, err
 	}
 
 	// Create a new user instance
@@ -39,11 +75,15 @@ func (s *UserService) CreateUser(name, email, password string) (*User, error) {
 		ID:       uuid.New(), // Generate a new UUID for the user
 		Name:     name,
 		Email:    email,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
+		Role:     RoleOwner, // Root users own their namespace
 	}
 
 	// Save the user using the repository
 	createdUser, err := s.UserRepo.Save(user)
+	if errors.Is(err, ErrEmailTaken) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
@@ -51,25 +91,39 @@ func (s *UserService) CreateUser(name, email, password string) (*User, error) {
 	return createdUser, nil
 }
 
-// CreateSubUser handles the creation of a new sub-user.
-func (s *UserService) CreateSubUser(rootUserID uuid.UUID, name, email, password string) (*User, error) {
+// CreateSubUser handles the creation of a new sub-user. Sub-users live in the
+// namespace of their root user, so the root must exist and not be a sub-user itself.
+func (s *UserService) CreateSubUser(rootUserID uuid.UUID, name, email, password string, role Role) (*User, error) {
+	if err := validateSubUserRole(role); err != nil {
+		return nil, err
+	}
+
+	root, err := s.UserRepo.FindByID(rootUserID)
+	if err != nil || !root.IsRoot() {
+		return nil, ErrRootUserNotFound
+	}
+
 	// Hash the password before saving it
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.Hasher.Hash(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %v", err)
+		return nil, err
 	}
 
 	// Create a new sub-user instance
 	subUser := &User{
-		ID:       uuid.New(), // Generate a new UUID for the sub-user
-		Name:     name,
-		Email:    email,
-		Password: string(hashedPassword),
-		// RootUserID:  rootUserID, // Link to the root user
+		ID:         uuid.New(), // Generate a new UUID for the sub-user
+		Name:       name,
+		Email:      email,
+		Password:   hashedPassword,
+		RootUserID: root.ID, // Link to the root user
+		Role:       role,
 	}
 
 	// Save the sub-user using the repository
 	createdUser, err := s.UserRepo.SaveSubUser(subUser)
+	if errors.Is(err, ErrEmailTaken) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create sub-user: %v", err)
 	}
@@ -77,6 +131,74 @@ func (s *UserService) CreateSubUser(rootUserID uuid.UUID, name, email, password
 	return createdUser, nil
 }
 
+// GetSubUsers lists the sub-users of a root user.
+func (s *UserService) GetSubUsers(rootUserID uuid.UUID) ([]*User, error) {
+	return s.UserRepo.FindSubUsers(rootUserID)
+}
+
+// GetSubUser fetches a sub-user, making sure it belongs to the root user.
+func (s *UserService) GetSubUser(rootUserID, id uuid.UUID) (*User, error) {
+	user, err := s.UserRepo.FindByID(id)
+	if err != nil || user.RootUserID != rootUserID {
+		return nil, ErrSubUserNotFound
+	}
+	return user, nil
+}
+
+// UpdateSubUser changes the name and/or role of a sub-user. Nil fields are kept.
+func (s *UserService) UpdateSubUser(rootUserID, id uuid.UUID, name *string, role *Role) (*User, error) {
+	user, err := s.GetSubUser(rootUserID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != nil {
+		user.Name = *name
+	}
+	if role != nil {
+		if err := validateSubUserRole(*role); err != nil {
+			return nil, err
+		}
+		user.Role = *role
+	}
+
+	if err := s.UserRepo.UpdateSubUser(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// SetSubUserDisabled disables or re-enables a sub-user.
+func (s *UserService) SetSubUserDisabled(rootUserID, id uuid.UUID, disabled bool) (*User, error) {
+	user, err := s.GetSubUser(rootUserID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var at *time.Time
+	if disabled {
+		now := time.Now()
+		at = &now
+	}
+
+	if err := s.UserRepo.SetDisabledAt(user.ID, at); err != nil {
+		return nil, err
+	}
+
+	user.DisabledAt = at
+	return user, nil
+}
+
+// DeleteSubUser removes a sub-user of the root user.
+func (s *UserService) DeleteSubUser(rootUserID, id uuid.UUID) error {
+	user, err := s.GetSubUser(rootUserID, id)
+	if err != nil {
+		return err
+	}
+	return s.UserRepo.Delete(user.ID)
+}
+
 // GetUserByID handles fetching a user by ID.
 func (s *UserService) GetUserByID(id uuid.UUID) (*User, error) {
 	user, err := s.UserRepo.FindByID(id)
@@ -97,3 +219,59 @@ func (s *UserService) GetUserByEmail(email string) (*User, error) {
 	}
 	return user, nil
 }
+
+// UpdatePassword hashes and stores a new password for a user.
+func (s *UserService) UpdatePassword(id uuid.UUID, password string) error {
+	hashedPassword, err := s.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.UserRepo.UpdatePassword(id, hashedPassword); err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateProfile changes the name and/or email of a user. Nil fields are kept.
+// A new email must be verified again, which the returned flag signals.
+func (s *UserService) UpdateProfile(id uuid.UUID, name, email *string) (*User, bool, error) {
+	user, err := s.UserRepo.FindByID(id)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch user by ID: %v", err)
+	}
+
+	if name != nil {
+		user.Name = *name
+	}
+
+	emailChanged := email != nil && *email != user.Email
+	if emailChanged {
+		user.Email = *email
+		user.EmailVerifiedAt = nil
+	}
+
+	if err := s.UserRepo.UpdateProfile(user); err != nil {
+		return nil, false, err
+	}
+
+	return user, emailChanged, nil
+}
+
+// MarkEmailVerified records that the user confirmed their email address.
+func (s *UserService) MarkEmailVerified(id uuid.UUID) error {
+	if err := s.UserRepo.MarkEmailVerified(id, time.Now()); err != nil {
+		return fmt.Errorf("failed to verify email: %v", err)
+	}
+	return nil
+}
+
+// validateSubUserRole checks a role can be given to a sub-user. Only root
+// users are owners.
+func validateSubUserRole(role Role) error {
+	if _, err := ParseRole(string(role)); err != nil || role == RoleOwner {
+		return ErrInvalidRole
+	}
+	return nil
+}
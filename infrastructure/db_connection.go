@@ -28,7 +28,9 @@ func NewDBConnection() (*sql.DB, error) {
 	// }
 
 	// Create the DSN (Data Source Name) for the database connection
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", dbUser, dbPassword, dbHost, dbPort, dbName)
+	// parseTime lets TIMESTAMP columns scan straight into time.Time,
+	// multiStatements lets a migration file hold a table and its indexes
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&multiStatements=true", dbUser, dbPassword, dbHost, dbPort, dbName)
 
 	var db *sql.DB
 	var err error
@@ -0,0 +1,11 @@
+CREATE TABLE user_identities (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL REFERENCES users(id),
+  issuer VARCHAR(255) NOT NULL,            -- OIDC issuer URL of the identity provider
+  subject VARCHAR(255) NOT NULL,           -- "sub" claim, stable per user at that issuer
+  email VARCHAR(100) NOT NULL,             -- Email at the time the identity was linked
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  last_login_at TIMESTAMP NULL,
+  UNIQUE KEY uq_user_identities_subject (issuer, subject)
+);
+CREATE INDEX idx_user_identities_user ON user_identities(user_id);
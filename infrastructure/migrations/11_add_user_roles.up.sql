@@ -0,0 +1,5 @@
+-- Unknown rows get the least privileged role
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'viewer';
+
+-- Root users own their namespace
+UPDATE users SET role = 'owner' WHERE root_user_id IS NULL;
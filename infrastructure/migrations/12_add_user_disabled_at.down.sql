@@ -0,0 +1,2 @@
+DROP INDEX idx_users_root_user ON users;
+ALTER TABLE users DROP COLUMN disabled_at;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP NULL; -- Set while the root user has disabled the sub-user
+CREATE INDEX idx_users_root_user ON users(root_user_id);
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP NULL; -- Soft delete, the row is removed once the teardown is confirmed
+
+CREATE TABLE account_deletions (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL,
+  status VARCHAR(20) NOT NULL,             -- pending, running, tearing_down, completed, cancelled or failed
+  cancel_token_hash CHAR(64) NOT NULL UNIQUE, -- SHA-256 of the token that cancels the deletion during the grace period
+  scheduled_for TIMESTAMP NOT NULL,        -- When the next teardown attempt may start
+  attempts INT NOT NULL DEFAULT 0,
+  last_error TEXT NULL,
+  claimed_at TIMESTAMP NULL,               -- Set by the replica running the teardown
+  requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  finished_at TIMESTAMP NULL               -- Completed, cancelled or failed
+);
+CREATE INDEX idx_account_deletions_due ON account_deletions(status, scheduled_for);
+CREATE INDEX idx_account_deletions_user ON account_deletions(user_id);
@@ -0,0 +1,7 @@
+CREATE TABLE failed_attempts (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  failure_key VARCHAR(255) NOT NULL, -- Client IP or prefixed user ID
+  failed_at TIMESTAMP(6) NOT NULL    -- Microseconds keep bursts of failures apart
+);
+CREATE INDEX idx_failed_attempts_key ON failed_attempts(failure_key, failed_at);
+CREATE INDEX idx_failed_attempts_failed_at ON failed_attempts(failed_at);
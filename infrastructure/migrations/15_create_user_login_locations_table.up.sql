@@ -0,0 +1,8 @@
+CREATE TABLE user_login_locations (
+  user_id CHAR(36) PRIMARY KEY REFERENCES users(id), -- Only the last located login is kept
+  ip VARCHAR(45) NOT NULL,
+  country VARCHAR(100) NOT NULL,
+  latitude DOUBLE NOT NULL,
+  longitude DOUBLE NOT NULL,
+  logged_in_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,15 @@
+CREATE TABLE user_devices (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL REFERENCES users(id),
+  name VARCHAR(100) NOT NULL,              -- Chosen by the user, guessed from the user agent until then
+  cookie_hash CHAR(64) NOT NULL,           -- SHA-256 of the device cookie, the cookie itself is never stored
+  fingerprint CHAR(64) NOT NULL,           -- SHA-256 of the user agent and IP prefix
+  user_agent VARCHAR(512) NOT NULL,
+  ip_prefix VARCHAR(64) NOT NULL,          -- /24 for IPv4, /48 for IPv6
+  last_ip VARCHAR(45) NOT NULL,            -- Long enough for IPv6
+  created_at TIMESTAMP NOT NULL,
+  last_seen_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP NULL
+);
+CREATE INDEX idx_user_devices_cookie ON user_devices(user_id, cookie_hash);
+CREATE INDEX idx_user_devices_fingerprint ON user_devices(user_id, fingerprint);
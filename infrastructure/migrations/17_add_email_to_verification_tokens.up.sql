@@ -0,0 +1,3 @@
+-- Links only confirm the address they were sent to. Pending links from
+-- before have no address and are rejected, users ask for a new one.
+ALTER TABLE email_verification_tokens ADD COLUMN email VARCHAR(100) NULL;
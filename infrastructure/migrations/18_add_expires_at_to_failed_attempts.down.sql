@@ -0,0 +1,3 @@
+CREATE INDEX idx_failed_attempts_failed_at ON failed_attempts(failed_at);
+DROP INDEX idx_failed_attempts_expires_at ON failed_attempts;
+ALTER TABLE failed_attempts DROP COLUMN expires_at;
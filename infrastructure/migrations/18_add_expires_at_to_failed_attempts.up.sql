@@ -0,0 +1,7 @@
+-- Keys use windows from 10 minutes (IP throttling) to days (account
+-- lockouts), each row now says when it can be pruned.
+ALTER TABLE failed_attempts ADD COLUMN expires_at TIMESTAMP(6) NULL;
+UPDATE failed_attempts SET expires_at = failed_at + INTERVAL 1 DAY;
+ALTER TABLE failed_attempts MODIFY expires_at TIMESTAMP(6) NOT NULL;
+CREATE INDEX idx_failed_attempts_expires_at ON failed_attempts(expires_at);
+DROP INDEX idx_failed_attempts_failed_at ON failed_attempts;
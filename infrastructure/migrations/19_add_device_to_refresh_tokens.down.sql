@@ -0,0 +1,2 @@
+DROP INDEX idx_refresh_tokens_device ON refresh_tokens;
+ALTER TABLE refresh_tokens DROP COLUMN device_id;
@@ -0,0 +1,4 @@
+-- Sessions remember the device they were started on, so forgetting a device
+-- ends them. Rotated tokens inherit the device of the token they replace.
+ALTER TABLE refresh_tokens ADD COLUMN device_id CHAR(36) NULL;
+CREATE INDEX idx_refresh_tokens_device ON refresh_tokens(device_id);
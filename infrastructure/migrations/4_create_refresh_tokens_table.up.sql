@@ -0,0 +1,12 @@
+CREATE TABLE refresh_tokens (
+  id CHAR(36) PRIMARY KEY,                          -- UUID of the token record
+  user_id CHAR(36) NOT NULL REFERENCES users(id),   -- Owner of the token
+  family_id CHAR(36) NOT NULL,                      -- Shared by every token rotated from the same login
+  token_hash CHAR(64) NOT NULL UNIQUE,              -- SHA-256 of the opaque token, the token itself is never stored
+  expires_at TIMESTAMP NOT NULL,                    -- Absolute expiry of this token
+  rotated_at TIMESTAMP NULL,                        -- Set once the token has been exchanged for a new one
+  revoked_at TIMESTAMP NULL,                        -- Set when the token (or its family) is revoked
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens(user_id);
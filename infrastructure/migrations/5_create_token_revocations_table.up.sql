@@ -0,0 +1,10 @@
+CREATE TABLE revoked_tokens (
+  jti CHAR(36) PRIMARY KEY,      -- ID of the revoked access token
+  expires_at TIMESTAMP NOT NULL  -- Original expiry, the row can be dropped afterwards
+);
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+
+CREATE TABLE user_session_revocations (
+  user_id CHAR(36) PRIMARY KEY REFERENCES users(id), -- User that logged out of all sessions
+  revoked_before TIMESTAMP NOT NULL                  -- Tokens issued before this instant are rejected
+);
@@ -0,0 +1,16 @@
+CREATE TABLE mfa_settings (
+  user_id CHAR(36) PRIMARY KEY REFERENCES users(id), -- One TOTP secret per user
+  secret VARCHAR(64) NOT NULL,                       -- Base32 TOTP secret (schema is encrypted at rest)
+  enabled_at TIMESTAMP NULL,                         -- NULL while the enrollment is not confirmed
+  last_used_step BIGINT NOT NULL DEFAULT 0,          -- Last accepted time step, prevents code replay
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE mfa_recovery_codes (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL REFERENCES users(id),
+  code_hash CHAR(64) NOT NULL,                       -- SHA-256 of the normalized recovery code
+  used_at TIMESTAMP NULL,                            -- Set once the code has been used
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_mfa_recovery_codes_user ON mfa_recovery_codes(user_id);
@@ -0,0 +1,9 @@
+CREATE TABLE password_reset_tokens (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL REFERENCES users(id),
+  token_hash CHAR(64) NOT NULL UNIQUE,   -- SHA-256 of the token sent by email
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP NULL,                -- Set once the token was used or superseded
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_password_reset_tokens_user ON password_reset_tokens(user_id);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP NULL; -- NULL until the user followed the verification link
+
+-- Existing accounts start unverified like new ones, nobody ever confirmed
+-- their addresses. They ask for a link with /auth/email/resend/.
+
+CREATE TABLE email_verification_tokens (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL REFERENCES users(id),
+  token_hash CHAR(64) NOT NULL UNIQUE,   -- SHA-256 of the token sent by email
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP NULL,                -- Set once the token was used or superseded
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_email_verification_tokens_user ON email_verification_tokens(user_id, created_at);
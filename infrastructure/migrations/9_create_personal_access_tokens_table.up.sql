@@ -0,0 +1,14 @@
+CREATE TABLE personal_access_tokens (
+  id CHAR(36) PRIMARY KEY,
+  user_id CHAR(36) NOT NULL REFERENCES users(id),
+  name VARCHAR(100) NOT NULL,              -- Label chosen by the user, e.g. "github-actions"
+  token_hash CHAR(64) NOT NULL UNIQUE,     -- SHA-256 of the token, the token itself is shown once
+  token_prefix VARCHAR(20) NOT NULL,       -- First characters of the token, helps users recognise it
+  scopes VARCHAR(255) NOT NULL,            -- Space separated scopes
+  expires_at TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP NULL,
+  last_used_ip VARCHAR(45) NULL,           -- Long enough for IPv6
+  revoked_at TIMESTAMP NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_personal_access_tokens_user ON personal_access_tokens(user_id);
@@ -3,8 +3,15 @@ package main
 import (
 	// "flag"
 	"net/http"
+	"os"
+	"shade_web_server/core/accounts"
+	"shade_web_server/core/auth"
+	"shade_web_server/core/mail"
+	"shade_web_server/core/trust"
+	"shade_web_server/core/users"
 	"shade_web_server/infrastructure"
 	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
 	"shade_web_server/routers"
 
 	log "github.com/sirupsen/logrus"
@@ -37,9 +44,85 @@ func main() {
 		log.Fatalf("Failed to connect to the cluster: %v", err)
 	}
 
+	// Load the JWT signing keys, shared by the auth service and the JWT middleware
+	keys, err := auth.LoadKeyProvider()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	middleware.Keys = keys
+
+	// Password hashing for new and upgraded passwords, Argon2id by default
+	users.DefaultPasswordHasher, err = users.NewPasswordHasherFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	// Failed attempts, account lockouts and flagged logins shared by every
+	// replica when FAILURE_STORE is mysql or redis
+	failureStore, err := trust.NewFailureStoreFromEnv(dbConn)
+	if err != nil {
+		log.Fatalf("Failed to configure the failed attempts store: %v", err)
+	}
+	trust.FailedTracker.SetStore(failureStore)
+	trust.AccountLocks.SetStore(failureStore)
+	trust.FlaggedLogins.SetStore(failureStore)
+
+	// Throttling fails open when the store is down, the logs must say so
+	logStoreError := func(key string, err error) {
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "failure_store_error",
+			"key":   key,
+			"error": err.Error(),
+		}).Error("Failed attempts store unavailable, not throttling")
+	}
+	trust.FailedTracker.SetErrorHandler(logStoreError)
+	trust.AccountLocks.SetErrorHandler(logStoreError)
+	trust.FlaggedLogins.SetErrorHandler(logStoreError)
+
+	// Proxies allowed to tell the client IP through forwarding headers
+	trust.TrustedProxies, err = trust.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+
+	// Where the trust score looks up the country and timezone of client IPs
+	geoIPResolver, err := trust.NewGeoIPResolverFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure GeoIP: %v", err)
+	}
+	trustConfig, err := trust.NewConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure the trust engine: %v", err)
+	}
+	trust.DefaultTrustEngine = trust.NewTrustEngine(trustConfig, geoIPResolver)
+
+	// Last login locations, for impossible travel detection across replicas
+	trust.DefaultTrustEngine.SetLocationStore(trust.NewMySQLLoginLocationStore(dbConn))
+
+	// Users allowed to list every account
+	middleware.PlatformAdmins = middleware.ParsePlatformAdmins(os.Getenv("PLATFORM_ADMIN_IDS"))
+
+	// Outgoing emails (password resets, verification links)
+	mailer, err := mail.NewMailerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure the mailer: %v", err)
+	}
+
+	// Single sign-on, disabled unless OIDC_ISSUER_URL is set
+	oidcConfig, err := auth.LoadOIDCConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure OIDC: %v", err)
+	}
+
+	// Time users have to cancel the deletion of their account
+	deletionGracePeriod, err := accounts.GracePeriodFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure account deletion: %v", err)
+	}
+
 	// Initialize the routers
-	userRouter := routers.InitializeUsersRouter(dbConn)
-	authRouter := routers.InitializeAuthRouter(dbConn, cluster)
+	userRouter := routers.InitializeUsersRouter(dbConn, cluster, metrics, keys, mailer, deletionGracePeriod)
+	authRouter := routers.InitializeAuthRouter(dbConn, cluster, keys, mailer, oidcConfig)
 	containerRouter := routers.InitializeContainersRouter(cluster, metrics)
 	trustRouter := routers.InitializeTrustRouter()
 
@@ -47,6 +130,7 @@ func main() {
 	mainRouter := http.NewServeMux()
 	mainRouter.Handle("/users/", userRouter)
 	mainRouter.Handle("/auth/", authRouter)
+	mainRouter.Handle("/.well-known/", authRouter)
 	mainRouter.Handle("/container/", containerRouter)
 	mainRouter.Handle("/trust/", trustRouter)
 	// added a health check endpoint for testing
@@ -62,8 +146,9 @@ func main() {
 	// Configure CORS
 	corsOptions := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}), // Allow all origins (change to specific domains in production)
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", middleware.ElevatedTokenHeader}),
+		handlers.ExposedHeaders([]string{middleware.StepUpRequiredHeader}),
 		handlers.AllowCredentials(),
 	)
 
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RequireVerifiedEmail rejects users that did not confirm their email yet.
+// It must run after JWTAuthMiddleware.
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(ClaimsKey).(jwt.MapClaims)
+
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			http.Error(w, "Email address not verified", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
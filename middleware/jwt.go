@@ -2,17 +2,40 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	"shade_web_server/core/auth"
+	"shade_web_server/core/trust"
+	"shade_web_server/infrastructure/logger"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var JwtSecret = []byte("your_secret_key")
+// Keys verifies token signatures; main sets it to the same provider the
+// AuthService signs with.
+var Keys *auth.KeyProvider
+
+// Revocations is consulted for every token; the auth router replaces it with
+// the store shared by all replicas.
+var Revocations auth.RevocationStore = auth.NewMemoryRevocationStore()
+
+// MFA tells which users have two-factor authentication, they step up with a
+// code on high trust levels. Without it the password is always accepted.
+var MFA *auth.MFAService
+
+// PersonalTokens verifies personal access tokens; they are rejected while it is nil.
+var PersonalTokens *auth.PersonalAccessTokenService
 
 type contextKey string
 
-const UserIDKey contextKey = "user_id"
+const (
+	UserIDKey    contextKey = "user_id"
+	NamespaceKey contextKey = "namespace" // Namespace the user acts in, the root user's for sub-users
+	ClaimsKey    contextKey = "claims"    // jwt.MapClaims of the verified token
+	ScopesKey    contextKey = "scopes"    // []string, only set for personal access tokens
+)
 
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,22 +47,21 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			// Check signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return JwtSecret, nil
-		})
+		if strings.HasPrefix(tokenStr, auth.PersonalAccessTokenPrefix) {
+			personalTokenAuth(w, r, next, tokenStr)
+			return
+		}
 
-		if err != nil || !token.Valid {
+		claims, err := Keys.Parse(tokenStr)
+		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		// MFA challenges and other special purpose tokens are signed with the
+		// same keys but must never open the API
+		if typ, _ := claims["typ"].(string); typ != auth.TokenTypeAccess {
+			http.Error(w, "Invalid token type", http.StatusUnauthorized)
 			return
 		}
 
@@ -49,7 +71,91 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		jti, ok := claims["jti"].(string)
+		if !ok {
+			http.Error(w, "Missing jti in token", http.StatusUnauthorized)
+			return
+		}
+
+		issuedAt, err := claims.GetIssuedAt()
+		if err != nil || issuedAt == nil {
+			http.Error(w, "Missing iat in token", http.StatusUnauthorized)
+			return
+		}
+
+		revoked, err := auth.IsTokenRevoked(Revocations, userID, jti, issuedAt.Time)
+		if err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "token_revocation_check_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to check token revocation")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			http.Error(w, "Token revoked", http.StatusUnauthorized)
+			return
+		}
+
+		// Tokens issued before roles existed only belong to root users
+		namespace, ok := claims["namespace"].(string)
+		if !ok {
+			namespace = userID
+		}
+
 		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		ctx = context.WithValue(ctx, NamespaceKey, namespace)
+		ctx = context.WithValue(ctx, ClaimsKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// personalTokenAuth authenticates a request carrying a personal access token.
+// Handlers read the same context values as for a JWT, with claims built from
+// the token, and RequireScope limits what the token can reach.
+func personalTokenAuth(w http.ResponseWriter, r *http.Request, next http.Handler, tokenStr string) {
+	if PersonalTokens == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	clientIP := trust.GetIPFromRequest(r)
+
+	token, user, err := PersonalTokens.Authenticate(tokenStr, clientIP)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPersonalAccessToken) {
+			logger.Log.WithFields(map[string]interface{}{
+				"event": "personal_token_rejected",
+				"ip":    clientIP,
+			}).Warn("Invalid personal access token")
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "personal_token_check_failed",
+			"ip":    clientIP,
+			"error": err.Error(),
+		}).Error("Failed to check personal access token")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	userID := token.UserID.String()
+	claims := jwt.MapClaims{
+		"typ":            "personal_access_token",
+		"user_id":        userID,
+		"token_id":       token.ID.String(),
+		"email_verified": user.EmailVerified(), // Unverified again after an email change
+		"scopes":         token.Scopes,
+		"role":           string(user.Role),
+		"namespace":      user.Namespace(),
+		"exp":            token.ExpiresAt.Unix(),
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey, userID)
+	ctx = context.WithValue(ctx, NamespaceKey, user.Namespace())
+	ctx = context.WithValue(ctx, ClaimsKey, claims)
+	ctx = context.WithValue(ctx, ScopesKey, token.Scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
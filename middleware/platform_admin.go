@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"shade_web_server/infrastructure/logger"
+)
+
+// PlatformAdmins holds the IDs of the users that operate Shade itself and may
+// see every account; main loads it from PLATFORM_ADMIN_IDS.
+var PlatformAdmins = map[string]bool{}
+
+// ParsePlatformAdmins parses a comma separated list of user IDs.
+func ParsePlatformAdmins(value string) map[string]bool {
+	admins := map[string]bool{}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			admins[id] = true
+		}
+	}
+	return admins
+}
+
+// RequirePlatformAdmin rejects everybody but platform admins. Namespace roles
+// do not matter here. It must run after JWTAuthMiddleware.
+func RequirePlatformAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(UserIDKey).(string)
+
+		if !PlatformAdmins[userID] {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "platform_admin_denied",
+				"user_id": userID,
+				"method":  r.Method,
+				"path":    r.URL.Path,
+			}).Warn("Platform admin route accessed by another user")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+
+	"shade_web_server/core/users"
+	"shade_web_server/infrastructure/logger"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RequirePermission rejects users whose role does not grant a permission.
+// It must run after JWTAuthMiddleware.
+func RequirePermission(permission users.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value(ClaimsKey).(jwt.MapClaims)
+
+			role, ok := claims["role"].(string)
+			if !ok {
+				// Issued before roles existed, a refresh adds the claim
+				http.Error(w, "Token has no role, please log in again", http.StatusUnauthorized)
+				return
+			}
+
+			if !users.Role(role).Can(permission) {
+				logger.Log.WithFields(map[string]interface{}{
+					"event":      "permission_denied",
+					"user_id":    r.Context().Value(UserIDKey),
+					"role":       role,
+					"permission": string(permission),
+					"method":     r.Method,
+					"path":       r.URL.Path,
+				}).Warn("Role does not grant permission")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRootUser rejects sub-users, whatever their role. Root users act in
+// their own namespace. It must run after JWTAuthMiddleware.
+func RequireRootUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(UserIDKey).(string)
+		namespace, _ := r.Context().Value(NamespaceKey).(string)
+
+		if userID == "" || userID != namespace {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "root_user_required",
+				"user_id": userID,
+				"method":  r.Method,
+				"path":    r.URL.Path,
+			}).Warn("Sub-user attempted a root user action")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
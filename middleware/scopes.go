@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"shade_web_server/core/auth"
+)
+
+// RequireScope limits personal access tokens to routes covered by their
+// scopes. Requests authenticated with a JWT act with the user's full rights
+// and pass through. It must run after JWTAuthMiddleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, isPersonalToken := r.Context().Value(ScopesKey).([]string)
+			if isPersonalToken && !containsScope(scopes, scope) {
+				http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectPersonalAccessTokens keeps personal access tokens away from account
+// management, so a leaked CI token cannot create more tokens, change
+// credentials or turn off two-factor authentication.
+func RejectPersonalAccessTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "+auth.PersonalAccessTokenPrefix) {
+			http.Error(w, "Personal access tokens cannot be used here", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
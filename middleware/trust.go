@@ -2,34 +2,140 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"shade_web_server/core/auth"
 	"shade_web_server/core/trust"
+	"shade_web_server/infrastructure/logger"
 	"time"
 )
 
+// ElevatedTokenHeader carries the token returned by POST /auth/step-up
+const ElevatedTokenHeader = "X-Elevated-Token"
+
+// StepUpRequiredHeader names the trust level a step-up response asks for
+const StepUpRequiredHeader = "X-Step-Up-Required"
+
 // const geoIPTimeout = 500 * time.Millisecond
 
 // TrustMiddleware runs the trust‑score check before anything else.
 func TrustMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := trust.GetIPFromRequest(r)
-		ua := r.UserAgent()
+	return RequireTrust(trust.TrustLevelBasic)(next)
+}
 
-		ctx, cancel := context.WithTimeout(r.Context(), 500*time.Millisecond)
-		defer cancel()
+// RequireTrust scores the request and applies the decision of level: high
+// scores pass, medium scores need an elevated token from a recent step-up,
+// low scores are denied. Users flagged after a suspicious login have to step
+// up even with a high score. Stepping up needs a logged in user, so on routes
+// without JWTAuthMiddleware a step-up decision is a denial in practice.
+// Personal access tokens are scored too, without the user agent signal, and
+// denied where a session would step up since they cannot.
+func RequireTrust(level trust.TrustLevel) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, isPersonalToken := r.Context().Value(ScopesKey).([]string)
+			userID, _ := r.Context().Value(UserIDKey).(string)
 
-		result := trust.DefaultTrustEngine.CalculateTrustScore(ctx, ip, ua)
+			ip := trust.GetIPFromRequest(r)
+			ua := r.UserAgent()
 
-		if penalized,_ := trust.FailedTracker.ShouldPenalize(ip); penalized {
-			result.Score = 0
-			result.Reasons = append(result.Reasons, "Too many failed login attempts")
-		}
+			ctx, cancel := context.WithTimeout(r.Context(), 500*time.Millisecond)
+			defer cancel()
+
+			var result trust.TrustResult
+			if isPersonalToken {
+				result = trust.DefaultTrustEngine.CalculateTokenTrustScore(ctx, ip, ua)
+			} else {
+				result = trust.DefaultTrustEngine.CalculateTrustScore(ctx, ip, ua)
+			}
+
+			if penalized, _ := trust.FailedTracker.ShouldPenalize(ip); penalized {
+				result.Score = 0
+				result.Reasons = append(result.Reasons, "Too many failed login attempts")
+			}
+
+			decision := level.Decide(result.Score)
 
-		if result.Score < 30 {
-			http.Error(w, "Access denied: low trust score", http.StatusForbidden)
-			return
+			// Sessions of a user flagged after a suspicious login step up
+			// even when the request itself looks fine. Personal access
+			// tokens were not created by that login.
+			if decision == trust.DecisionAllow && userID != "" && !isPersonalToken && trust.FlaggedLogins.IsFlagged(userID) {
+				decision = trust.DecisionStepUp
+				result.Reasons = append(result.Reasons, "Suspicious login without a second factor")
+			}
+
+			if decision == trust.DecisionStepUp {
+				switch {
+				case isPersonalToken:
+					decision = trust.DecisionDeny
+					result.Reasons = append(result.Reasons, "Personal access tokens cannot step up")
+				case hasElevatedToken(r, level):
+					decision = trust.DecisionAllow
+				}
+			}
+
+			if decision != trust.DecisionAllow {
+				logger.Log.WithFields(map[string]interface{}{
+					"event":          "trust_check_failed",
+					"user_id":        userID,
+					"ip":             ip,
+					"method":         r.Method,
+					"path":           r.URL.Path,
+					"trust_level":    level.Name,
+					"decision":       decision.String(),
+					"score":          result.Score,
+					"reasons":        result.Reasons,
+					"personal_token": isPersonalToken,
+				}).Warn("Request below the trust level of the route")
+			}
+
+			switch decision {
+			case trust.DecisionAllow:
+				next.ServeHTTP(w, r)
+			case trust.DecisionStepUp:
+				w.Header().Set(StepUpRequiredHeader, level.Name)
+				http.Error(w, "Step-up authentication required", http.StatusForbidden)
+			default:
+				http.Error(w, "Access denied: low trust score", http.StatusForbidden)
+			}
+		})
+	}
+}
+
+// hasElevatedToken reports whether the request carries a valid elevated
+// token of the logged in user. On levels asking for a second factor, users
+// with two-factor authentication must have stepped up with a code.
+func hasElevatedToken(r *http.Request, level trust.TrustLevel) bool {
+	tokenStr := r.Header.Get(ElevatedTokenHeader)
+	userID, _ := r.Context().Value(UserIDKey).(string)
+	if tokenStr == "" || userID == "" || Keys == nil {
+		return false
+	}
+
+	method, err := auth.VerifyElevatedToken(Keys, Revocations, tokenStr, userID)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidElevatedToken) {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "elevated_token_check_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to check elevated token")
 		}
+		return false
+	}
+
+	if !level.SecondFactor || method == auth.StepUpMethodTOTP || MFA == nil {
+		return true
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	mfaEnabled, err := MFA.IsEnabled(userID)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "elevated_token_check_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to look up two-factor settings")
+		return false
+	}
+	return !mfaEnabled
 }
@@ -0,0 +1,195 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"shade_web_server/core/accounts"
+	"shade_web_server/core/auth"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// deletionPollInterval is how often the worker looks for due deletions
+const deletionPollInterval = time.Minute
+
+// registerAccountDeletionRoutes sets up account deletion, its cancellation
+// and the status lookup for platform admins
+func registerAccountDeletionRoutes(r *mux.Router, deletionService *accounts.DeletionService, authService *auth.AuthService) {
+	r.Handle("/users/me", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleteAccountHandler(w, r, deletionService, authService)
+	}))).Methods("DELETE")
+
+	// Reached from the emailed link, the token is the only credential
+	r.HandleFunc("/users/deletion/cancel", func(w http.ResponseWriter, r *http.Request) {
+		cancelDeletionHandler(w, r, deletionService)
+	}).Methods("POST")
+
+	r.Handle("/users/deletions/{id}", middleware.JWTAuthMiddleware(middleware.RequirePlatformAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		getDeletionHandler(w, r, deletionService)
+	})))).Methods("GET")
+}
+
+// startDeletionWorker tears down due accounts until the process exits
+func startDeletionWorker(deletionService *accounts.DeletionService) {
+	ticker := time.NewTicker(deletionPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		processed, err := deletionService.ProcessDue()
+		if err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event": "account_deletion_worker_failed",
+				"error": err.Error(),
+			}).Error("Failed to process account deletions")
+		}
+		if processed > 0 {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":     "account_deletions_processed",
+				"processed": processed,
+			}).Info("Processed account deletions")
+		}
+	}
+}
+
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request, deletionService *accounts.DeletionService, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := authService.UserService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// A stolen session alone must not be enough to wipe an account
+	if !checkCurrentPassword(w, r, authService, user, requestBody.CurrentPassword) {
+		return
+	}
+
+	deletion, token, err := deletionService.RequestDeletion(user)
+	if err != nil && deletion == nil {
+		if errors.Is(err, accounts.ErrDeletionPending) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "account_deletion_request_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to request account deletion")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		// The deletion is scheduled, the response still carries the cancel token
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "account_deletion_email_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to send account deletion email")
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":         "account_deletion_requested",
+		"user_id":       userID,
+		"deletion_id":   deletion.ID.String(),
+		"scheduled_for": deletion.ScheduledFor,
+		"ip":            r.RemoteAddr,
+	}).Info("Account deletion requested")
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":            deletion.ID,
+		"status":        deletion.Status,
+		"scheduled_for": deletion.ScheduledFor,
+		"cancel_token":  token,
+	})
+}
+
+func cancelDeletionHandler(w http.ResponseWriter, r *http.Request, deletionService *accounts.DeletionService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	var request accounts.CancelDeletion
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	deletion, err := deletionService.CancelDeletion(request.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, accounts.ErrInvalidCancelToken):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, accounts.ErrDeletionNotCancelable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			logger.Log.WithFields(map[string]interface{}{
+				"event": "account_deletion_cancel_failed",
+				"error": err.Error(),
+			}).Error("Failed to cancel account deletion")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":       "account_deletion_cancelled",
+		"user_id":     deletion.UserID.String(),
+		"deletion_id": deletion.ID.String(),
+		"ip":          r.RemoteAddr,
+	}).Info("Account deletion cancelled")
+
+	json.NewEncoder(w).Encode(deletion)
+}
+
+func getDeletionHandler(w http.ResponseWriter, r *http.Request, deletionService *accounts.DeletionService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid deletion ID", http.StatusBadRequest)
+		return
+	}
+
+	deletion, err := deletionService.GetDeletion(id)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if deletion == nil {
+		http.Error(w, "Deletion not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(deletion)
+}
@@ -1,40 +1,110 @@
 package routers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"shade_web_server/core/auth"
+	"shade_web_server/core/mail"
 	"shade_web_server/core/namespace"
 	"shade_web_server/core/trust"
 	"shade_web_server/core/users"
 	"shade_web_server/infrastructure/logger"
 	"shade_web_server/middleware"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"k8s.io/client-go/kubernetes"
 )
 
-// InitializeAuthRouter sets up authentication routes
-func InitializeAuthRouter(dbConn *sql.DB, clientset *kubernetes.Clientset) *mux.Router {
+// InitializeAuthRouter sets up authentication routes. oidcConfig is nil when
+// single sign-on is not configured.
+func InitializeAuthRouter(dbConn *sql.DB, clientset *kubernetes.Clientset, keys *auth.KeyProvider, mailer mail.Mailer, oidcConfig *auth.OIDCConfig) *mux.Router {
 	repo := users.NewMySQLUserRepository(dbConn)
 	cluster := namespace.NewKubernetesNamespaceRepository(clientset)
 	userService := users.NewUserService(repo)
 	namespaceService := namespace.NewNamespaceService(cluster)
-	authService := auth.NewAuthService(userService)
+	refreshRepo := auth.NewMySQLRefreshTokenRepository(dbConn)
+	revocations := auth.NewMySQLRevocationStore(dbConn)
+	authService := auth.NewAuthService(userService, refreshRepo, revocations, keys)
+	authService.SetRehashFailureHandler(logRehashFailure)
+
+	mfaIssuer := os.Getenv("MFA_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "Shade"
+	}
+	mfaService := auth.NewMFAService(auth.NewMySQLMFARepository(dbConn), authService, mfaIssuer)
+	verificationService := auth.NewEmailVerificationService(auth.NewMySQLEmailVerificationRepository(dbConn), userService, mailer, appBaseURL())
+	tokenService := auth.NewPersonalAccessTokenService(auth.NewMySQLPersonalAccessTokenRepository(dbConn), userService)
+	resetService := auth.NewPasswordResetService(auth.NewMySQLPasswordResetRepository(dbConn), authService, mailer, appBaseURL())
+	deviceService := auth.NewDeviceService(auth.NewMySQLDeviceRepository(dbConn), refreshRepo)
+
+	// Tokens revoked on one replica must be rejected by all of them
+	middleware.Revocations = revocations
+	middleware.PersonalTokens = tokenService
+	middleware.MFA = mfaService
 
 	r := mux.NewRouter()
 
+	// Personal access tokens only reach the API routes their scopes cover
+	r.Use(middleware.RejectPersonalAccessTokens)
+
 	r.HandleFunc("/auth/signup/", func(w http.ResponseWriter, r *http.Request) {
-		signupHandler(w, r, userService, namespaceService)
+		signupHandler(w, r, userService, namespaceService, verificationService)
 	}).Methods("POST")
 
 	r.HandleFunc("/auth/login/", func(w http.ResponseWriter, r *http.Request) {
-		loginHandler(w, r, authService, namespaceService)
+		loginHandler(w, r, authService, mfaService, namespaceService, deviceService)
+	}).Methods("POST")
+
+	registerMFARoutes(r, mfaService, namespaceService, deviceService)
+	registerPasswordRoutes(r, resetService)
+	registerEmailVerificationRoutes(r, verificationService)
+	registerTokenRoutes(r, tokenService)
+	registerLockoutRoutes(r)
+	registerDeviceRoutes(r, deviceService)
+	registerStepUpRoutes(r, authService, mfaService)
+
+	// Tell users their account was locked, it may be under attack
+	trust.AccountLocks.SetNotifier(lockNotifier(userService, mailer))
+
+	// Tell users about logins from devices they never used
+	deviceService.SetNotifier(newDeviceNotifier(mailer))
+
+	if oidcConfig != nil {
+		oidcService := auth.NewOIDCService(auth.NewOIDCProvider(oidcConfig), auth.NewMySQLUserIdentityRepository(dbConn), authService)
+		registerOIDCRoutes(r, oidcService, mfaService, namespaceService, deviceService)
+	}
+
+	r.HandleFunc("/auth/refresh/", func(w http.ResponseWriter, r *http.Request) {
+		refreshHandler(w, r, authService)
 	}).Methods("POST")
 
+	r.Handle("/auth/logout/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logoutHandler(w, r, authService)
+	}))).Methods("POST")
+
+	r.Handle("/auth/logout/all/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logoutAllHandler(w, r, authService)
+	}))).Methods("POST")
+
+	// Public keys for services that verify Shade tokens without the HMAC secret
+	r.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(keys.JWKS())
+	}).Methods("GET")
+
 	r.Handle("/auth/me/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(middleware.UserIDKey).(string)
 
@@ -54,7 +124,15 @@ func InitializeAuthRouter(dbConn *sql.DB, clientset *kubernetes.Clientset) *mux.
 	return r
 }
 
-func signupHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, namespaceService *namespace.NamespaceService) {
+// appBaseURL is the frontend URL that links in emails point to
+func appBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:3000"
+}
+
+func signupHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, namespaceService *namespace.NamespaceService, verificationService *auth.EmailVerificationService) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
@@ -75,6 +153,17 @@ func signupHandler(w http.ResponseWriter, r *http.Request, userService *users.Us
 	}
 
 	user, err := userService.CreateUser(requestBody.Name, requestBody.Email, requestBody.Password)
+	if errors.Is(err, users.ErrEmailTaken) {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":  "signup_email_taken",
+			"user":   requestBody.Email,
+			"ip":     r.RemoteAddr,
+			"method": r.Method,
+			"path":   r.URL.Path,
+		}).Warn("Signup with an email already in use")
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		logger.Log.WithFields(map[string]interface{}{
 			"event":  "signup_failed",
@@ -98,6 +187,17 @@ func signupHandler(w http.ResponseWriter, r *http.Request, userService *users.Us
 		}).Error("Failed to create namespace for new user")
 	}
 
+	// Users can log in right away, but need to confirm their email before deploying
+	go func() {
+		if err := verificationService.SendVerification(user); err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "verification_email_failed",
+				"user_id": user.ID.String(),
+				"error":   err.Error(),
+			}).Error("Failed to send verification email")
+		}
+	}()
+
 	logger.Log.WithFields(map[string]interface{}{
 		"event":  "signup_success",
 		"user":   requestBody.Email,
@@ -110,7 +210,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request, userService *users.Us
 	json.NewEncoder(w).Encode(response)
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService, namespaceService *namespace.NamespaceService) {
+func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService, mfaService *auth.MFAService, namespaceService *namespace.NamespaceService, deviceService *auth.DeviceService) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
@@ -134,11 +234,39 @@ func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.Auth
 	clientIP := trust.GetIPFromRequest(r)
 	startTime := time.Now()
 
-	token, err := authService.AuthenticateUser(requestBody.Email, requestBody.Password)
+	// Locked accounts are rejected before the password is checked, so a
+	// locked account does not tell whether a guess was right
+	if locked, remaining := trust.AccountLocks.IsLocked(requestBody.Email); locked {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":         "login_account_locked",
+			"user":          requestBody.Email,
+			"ip":            clientIP,
+			"method":        r.Method,
+			"path":          r.URL.Path,
+			"remaining_sec": int(remaining.Seconds()),
+		}).Warn("Login to a locked account")
+		w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+		http.Error(w, "Account temporarily locked after too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := authService.AuthenticateUser(requestBody.Email, requestBody.Password)
+	if errors.Is(err, auth.ErrAccountDisabled) {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":  "login_disabled_account",
+			"user":   requestBody.Email,
+			"ip":     clientIP,
+			"method": r.Method,
+			"path":   r.URL.Path,
+		}).Warn("Login to a disabled account")
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		// Record failed login attempt and get current count
 		failedCount := trust.FailedTracker.RecordFailure(clientIP)
 		timeUntilReset := trust.FailedTracker.GetTimeUntilReset(clientIP)
+		lockedUntil := trust.AccountLocks.RecordFailure(requestBody.Email)
 
 		logger.Log.WithFields(map[string]interface{}{
 			"event":                "login_failed",
@@ -150,6 +278,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.Auth
 			"duration":             time.Since(startTime).Milliseconds(),
 			"failed_attempts":      failedCount,
 			"time_until_reset_sec": int(timeUntilReset.Seconds()),
+			"account_locked":       !lockedUntil.IsZero(),
 		}).Warn("Login failed")
 
 		// Return helpful information to client
@@ -159,23 +288,67 @@ func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.Auth
 		return
 	}
 
-	// On successful login, reset failed attempts for this IP
-	trust.FailedTracker.ResetFailures(clientIP)
+	// The password was right, the account starts over
+	trust.AccountLocks.ResetFailures(requestBody.Email)
 
-	// A way to create namespaces for existing users on login
-	userID, err := authService.GetUserID(requestBody.Email)
-
-	if err == nil && !namespaceService.Exists(userID) {
-		err := namespaceService.CreateNamespace(userID)
+	mfaEnabled, err := mfaService.IsEnabled(user.ID.String())
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "login_mfa_lookup_failed",
+			"user":  requestBody.Email,
+			"ip":    clientIP,
+			"error": err.Error(),
+		}).Error("Failed to look up two-factor settings")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
+	// The password is correct but the session only starts after the TOTP code
+	if mfaEnabled {
+		challenge, err := mfaService.CreateChallenge(user)
 		if err != nil {
 			logger.Log.WithFields(map[string]interface{}{
-				"event":   "namespace_creation_failed",
-				"user_id": userID,
-				"error":   err.Error(),
-			}).Info("Failed to create namespace for existing user")
+				"event": "login_mfa_challenge_failed",
+				"user":  requestBody.Email,
+				"ip":    clientIP,
+				"error": err.Error(),
+			}).Error("Failed to create two-factor challenge")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
+
+		logger.Log.WithFields(map[string]interface{}{
+			"event":    "login_mfa_required",
+			"user":     requestBody.Email,
+			"ip":       clientIP,
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"duration": time.Since(startTime).Milliseconds(),
+		}).Info("Password accepted, waiting for second factor")
+
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	// On successful login, reset failed attempts for this IP
+	trust.FailedTracker.ResetFailures(clientIP)
+
+	deviceID := evaluateLoginTrust(w, r, user, deviceService, false)
+
+	ensureNamespace(user.Namespace(), namespaceService)
+
+	tokens, err := authService.IssueTokens(user)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "login_token_error",
+			"user":  requestBody.Email,
+			"ip":    clientIP,
+			"error": err.Error(),
+		}).Error("Failed to issue tokens")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+	attachDeviceSession(deviceService, user, deviceID, tokens)
 
 	logger.Log.WithFields(map[string]interface{}{
 		"event":    "login_success",
@@ -186,8 +359,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.Auth
 		"duration": time.Since(startTime).Milliseconds(),
 	}).Info("Login successful")
 
-	response := map[string]string{"token": token}
-	jsonResponse, err := json.Marshal(response)
+	jsonResponse, err := json.Marshal(tokens)
 	if err != nil {
 		logger.Log.WithFields(map[string]interface{}{
 			"event": "login_json_error",
@@ -201,3 +373,212 @@ func loginHandler(w http.ResponseWriter, r *http.Request, authService *auth.Auth
 
 	w.Write(jsonResponse)
 }
+
+// ensureNamespace is a way to create namespaces for existing users on login
+func ensureNamespace(userID string, namespaceService *namespace.NamespaceService) {
+	if namespaceService.Exists(userID) {
+		return
+	}
+
+	if err := namespaceService.CreateNamespace(userID); err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "namespace_creation_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Info("Failed to create namespace for existing user")
+	}
+}
+
+// logRehashFailure reports a password hash that could not be upgraded at
+// login, it is retried at the next login
+func logRehashFailure(userID uuid.UUID, err error) {
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "password_rehash_failed",
+		"user_id": userID.String(),
+		"error":   err.Error(),
+	}).Error("Failed to upgrade password hash")
+}
+
+// evaluateLoginTrust compares a completed login with the previous ones of
+// the user, in place and device, and logs the result, so impossible travel
+// and new devices show up with the login events. It runs once every factor
+// was checked: the location it remembers is the reference for the next
+// login. An impossible travel or a new device without a second factor flags
+// the user, their sessions have to step up. The device cookie is set on w,
+// the device is returned for the session to be attached to, uuid.Nil if the
+// lookup failed.
+func evaluateLoginTrust(w http.ResponseWriter, r *http.Request, user *users.User, deviceService *auth.DeviceService, secondFactor bool) uuid.UUID {
+	ctx, cancel := context.WithTimeout(r.Context(), 500*time.Millisecond)
+	defer cancel()
+
+	userID := user.ID.String()
+	result := trust.DefaultTrustEngine.EvaluateLogin(ctx, userID, trust.GetIPFromRequest(r), r.UserAgent())
+
+	deviceID, newDevice := uuid.Nil, false
+	if login, err := recognizeDevice(w, r, user, deviceService, result.ClientIP); err != nil {
+		result.Reasons = append(result.Reasons, "Device lookup failed")
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "login_device_lookup_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to recognize login device")
+	} else {
+		deviceID, newDevice = login.Device.ID, login.New
+		if newDevice {
+			trust.DefaultTrustEngine.PenalizeUnknownDevice(&result)
+		}
+	}
+
+	if err := trust.DefaultTrustEngine.RememberLogin(userID, result); err != nil {
+		result.Reasons = append(result.Reasons, "Failed to remember login location")
+	}
+
+	flagged := (result.ImpossibleTravel || newDevice) && !secondFactor
+	if flagged {
+		if err := trust.FlaggedLogins.Flag(userID); err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "login_flag_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to flag suspicious login")
+		}
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":         "login_trust_evaluated",
+		"user_id":       userID,
+		"ip":            result.ClientIP,
+		"device_id":     deviceID.String(),
+		"score":         result.Score,
+		"reasons":       result.Reasons,
+		"country":       result.Country,
+		"timezone":      result.Timezone,
+		"new_device":    newDevice,
+		"second_factor": secondFactor,
+		"flagged":       flagged,
+	}).Info("Login trust score evaluated")
+
+	return deviceID
+}
+
+func refreshHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	var requestBody auth.Refresh
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil || requestBody.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := trust.GetIPFromRequest(r)
+
+	tokens, err := authService.RefreshTokens(requestBody.RefreshToken)
+	if err != nil {
+		fields := map[string]interface{}{
+			"event":  "token_refresh_failed",
+			"ip":     clientIP,
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"error":  err.Error(),
+		}
+
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			fields["event"] = "refresh_token_reuse"
+			logger.Log.WithFields(fields).Warn("Refresh token reused, token family revoked")
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		case errors.Is(err, auth.ErrInvalidRefreshToken):
+			logger.Log.WithFields(fields).Warn("Invalid refresh token")
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		default:
+			logger.Log.WithFields(fields).Error("Failed to refresh tokens")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":  "token_refresh_success",
+		"ip":     clientIP,
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Info("Tokens refreshed")
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+	claims := r.Context().Value(middleware.ClaimsKey).(jwt.MapClaims)
+
+	// The refresh token is optional, without it only the access token is revoked
+	var requestBody auth.Refresh
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	err = authService.Logout(userID, jti, expiresAt.Time, requestBody.RefreshToken)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "logout_failed",
+			"user_id": userID,
+			"ip":      r.RemoteAddr,
+			"error":   err.Error(),
+		}).Error("Failed to revoke session")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "logout",
+		"user_id": userID,
+		"ip":      r.RemoteAddr,
+	}).Info("User logged out")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+func logoutAllHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	if err := authService.LogoutAll(userID); err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "logout_all_failed",
+			"user_id": userID,
+			"ip":      r.RemoteAddr,
+			"error":   err.Error(),
+		}).Error("Failed to revoke all sessions")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "logout_all",
+		"user_id": userID,
+		"ip":      r.RemoteAddr,
+	}).Info("User logged out of all sessions")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out of all sessions"})
+}
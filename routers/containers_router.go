@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"shade_web_server/core/auth"
 	"shade_web_server/core/containers"
+	"shade_web_server/core/trust"
+	"shade_web_server/core/users"
 	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
 	"strconv"
 	"time"
 
@@ -23,25 +27,63 @@ func InitializeContainersRouter(clientset *kubernetes.Clientset, metrics *metric
 
 	r := mux.NewRouter()
 
-	r.HandleFunc("/container/create", createDeploymentHandler).Methods("POST")
-	r.HandleFunc("/container/{name}", getDeploymentStatusHandler).Methods("GET")
-	r.HandleFunc("/container/delete", deleteDeploymentHandler).Methods("DELETE")
-	r.HandleFunc("/container/{name}/stop", stopDeploymentHandler).Methods("PATCH")
-	r.HandleFunc("/container/{name}/start", startDeploymentHandler).Methods("PATCH")
-	r.HandleFunc("/container/{name}/restart", restartDeploymentHandler).Methods("PATCH")
-	r.HandleFunc("/container/namespace/{name}", getDeploymentsByNamespace).Methods("GET")
-	r.HandleFunc("/container/metrics", getContainerMetricsHandler).Methods("POST")
+	// Every container route acts on the caller's namespace, taken from the JWT
+	// or personal access token. Sub-users work in their root user's namespace.
+	r.Use(middleware.JWTAuthMiddleware)
+
+	// Personal access tokens need the scope, every caller needs the permission
+	// of their role and a trust score for the level of the action
+	guard := func(scope string, permission users.Permission, level trust.TrustLevel, handler http.Handler) http.Handler {
+		return middleware.RequireScope(scope)(middleware.RequirePermission(permission)(middleware.RequireTrust(level)(handler)))
+	}
+	read, write := auth.ScopeContainersRead, auth.ScopeContainersWrite
+	standard, high := trust.TrustLevelStandard, trust.TrustLevelHigh
+
+	r.Handle("/container/create", guard(write, users.PermContainersCreate, standard, middleware.RequireVerifiedEmail(http.HandlerFunc(createDeploymentHandler)))).Methods("POST")
+	r.Handle("/container/{name}", guard(read, users.PermContainersView, standard, http.HandlerFunc(getDeploymentStatusHandler))).Methods("GET")
+	r.Handle("/container/delete", guard(write, users.PermContainersDelete, high, http.HandlerFunc(deleteDeploymentHandler))).Methods("DELETE")
+	r.Handle("/container/{name}/stop", guard(write, users.PermContainersOperate, standard, http.HandlerFunc(stopDeploymentHandler))).Methods("PATCH")
+	r.Handle("/container/{name}/start", guard(write, users.PermContainersOperate, standard, http.HandlerFunc(startDeploymentHandler))).Methods("PATCH")
+	r.Handle("/container/{name}/restart", guard(write, users.PermContainersOperate, standard, http.HandlerFunc(restartDeploymentHandler))).Methods("PATCH")
+	r.Handle("/container/namespace/{name}", guard(read, users.PermContainersView, standard, http.HandlerFunc(getDeploymentsByNamespace))).Methods("GET")
+	r.Handle("/container/metrics", guard(read, users.PermMetricsView, standard, http.HandlerFunc(getContainerMetricsHandler))).Methods("POST")
 
 	return r
 }
 
+// authorizedNamespace returns the namespace the authenticated user works in.
+// If the request names a namespace explicitly it must be that one, otherwise
+// a 403 is written and false is returned.
+func authorizedNamespace(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+	namespace := r.Context().Value(middleware.NamespaceKey).(string)
+
+	if requested != "" && requested != namespace {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":     "namespace_access_denied",
+			"user_id":   userID,
+			"namespace": requested,
+			"ip":        r.RemoteAddr,
+			"method":    r.Method,
+			"path":      r.URL.Path,
+		}).Warn("Attempt to access another user's namespace")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+
+	return namespace, true
+}
+
 func getDeploymentsByNamespace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	w.Header().Set("Content-Type", "application/json")
 
-	var namespace = mux.Vars(r)["name"]
+	namespace, ok := authorizedNamespace(w, r, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
 
 	containers, err := containerService.ContainerRepo.GetAllByNamespace(namespace)
 	if err != nil {
@@ -110,7 +152,6 @@ func getDeploymentsByNamespace(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 }
 
-// TODO: Implement user validation instead of using value from request body
 func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
@@ -125,8 +166,14 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	namespace, ok := authorizedNamespace(w, r, container.Owner)
+	if !ok {
+		return
+	}
+
 	logger.Log.WithFields(map[string]interface{}{
 		"event":     "container_creation_attempt",
+		"user_id":   namespace,
 		"container": container.Name,
 		"image":     container.ImageTag,
 		"ip":        r.RemoteAddr,
@@ -137,7 +184,7 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 	// Assuming the container service is already initialized, create the deployment
 	createdDeployment, err := containerService.CreateContainer(
 		container.Name,
-		container.Owner,
+		namespace,
 		container.ImageTag,
 		container.Replicas,
 		container.MappedPort,
@@ -161,23 +208,11 @@ func getDeploymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get the container name from the URL path
 	var name = mux.Vars(r)["name"]
-	// var userToken = r.Header.Get("Authorization")
-
-	// TODO: Validate the user has access to the container (zero trust)
-	// Placeholder for user validation
-	// if userToken != "valid_token" {
-	// http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	// return
-	// }
-
-	// TODO: Get user from token
-	// Placeholder for user extraction from token
-	// user, err := getUserFromToken(userToken)
-	// if err != nil {
-	// 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	// 	return
-	// }
-	var user = "test"
+
+	user, ok := authorizedNamespace(w, r, "")
+	if !ok {
+		return
+	}
 
 	container, err := containerService.GetContainerStatus(user, name)
 	if err != nil {
@@ -211,12 +246,17 @@ func deleteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = containerService.DeleteContainer(deleteDeploymentRequest.User, deleteDeploymentRequest.ContainerName)
+	user, ok := authorizedNamespace(w, r, deleteDeploymentRequest.User)
+	if !ok {
+		return
+	}
+
+	err = containerService.DeleteContainer(user, deleteDeploymentRequest.ContainerName)
 	if err != nil {
 		logger.Log.WithFields(map[string]interface{}{
 			"event":          "container_deletion_error",
 			"container_name": deleteDeploymentRequest.ContainerName,
-			"user_id":        deleteDeploymentRequest.User,
+			"user_id":        user,
 			"error":          err.Error(),
 		}).Error("Failed to decode request into json")
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -226,7 +266,7 @@ func deleteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Log.WithFields(map[string]interface{}{
 		"event":          "container_deletion_success",
 		"container_name": deleteDeploymentRequest.ContainerName,
-		"user_id":        deleteDeploymentRequest.User,
+		"user_id":        user,
 	}).Error("Container Deleted Successfully")
 
 	json.NewEncoder(w).Encode(map[string]string{"message": "Container deleted"})
@@ -240,24 +280,12 @@ func stopDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get the container name from the URL path
 	var name = mux.Vars(r)["name"]
-	var userToken = r.Header.Get("Authorization")
 
-	// TODO: Validate the user has access to the container (zero trust)
-	// Placeholder for user validation
-	if userToken != "valid_token" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	user, ok := authorizedNamespace(w, r, "")
+	if !ok {
 		return
 	}
 
-	// TODO: Get user from token
-	// Placeholder for user extraction from token
-	// user, err := getUserFromToken(userToken)
-	// if err != nil {
-	// 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	// 	return
-	// }
-	var user = "danny"
-
 	err := containerService.StopContainer(user, name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -273,23 +301,11 @@ func startDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get the container name from the URL path
 	var name = mux.Vars(r)["name"]
-	// var userToken = r.Header.Get("Authorization")
-
-	// TODO: Validate the user has access to the container (zero trust)
-	// Placeholder for user validation
-	// if userToken != "valid_token" {
-	// 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	// 	return
-	// }
-
-	// TODO: Get user from token
-	// Placeholder for user extraction from token
-	// user, err := getUserFromToken(userToken)
-	// if err != nil {
-	// 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	// 	return
-	// }
-	var user = "test"
+
+	user, ok := authorizedNamespace(w, r, "")
+	if !ok {
+		return
+	}
 
 	err := containerService.StartContainer(user, name)
 	if err != nil {
@@ -308,24 +324,12 @@ func restartDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get the container name from the URL path
 	var name = mux.Vars(r)["name"]
-	var userToken = r.Header.Get("Authorization")
 
-	// TODO: Validate the user has access to the container (zero trust)
-	// Placeholder for user validation
-	if userToken != "valid_token" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	user, ok := authorizedNamespace(w, r, "")
+	if !ok {
 		return
 	}
 
-	// TODO: Get user from token
-	// Placeholder for user extraction from token
-	// user, err := getUserFromToken(userToken)
-	// if err != nil {
-	// 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	// 	return
-	// }
-	var user = "danny"
-
 	err := containerService.StopContainer(user, name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -365,7 +369,12 @@ func getContainerMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics, err := containerService.ContainerRepo.GetMetrics(containerMetricsRequest.User, containerMetricsRequest.ContainerName)
+	user, ok := authorizedNamespace(w, r, containerMetricsRequest.User)
+	if !ok {
+		return
+	}
+
+	metrics, err := containerService.ContainerRepo.GetMetrics(user, containerMetricsRequest.ContainerName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,226 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/mail"
+	"shade_web_server/core/users"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// registerDeviceRoutes lets users see, name and forget the devices they logged in from
+func registerDeviceRoutes(r *mux.Router, deviceService *auth.DeviceService) {
+	r.Handle("/auth/devices", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listDevicesHandler(w, r, deviceService)
+	}))).Methods("GET")
+
+	r.Handle("/auth/devices/{id}", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renameDeviceHandler(w, r, deviceService)
+	}))).Methods("PATCH")
+
+	r.Handle("/auth/devices/{id}", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revokeDeviceHandler(w, r, deviceService)
+	}))).Methods("DELETE")
+}
+
+// recognizeDevice finds the device of a login and refreshes its cookie
+func recognizeDevice(w http.ResponseWriter, r *http.Request, user *users.User, deviceService *auth.DeviceService, clientIP string) (*auth.DeviceLogin, error) {
+	cookie := ""
+	if existing, err := r.Cookie(auth.DeviceCookie); err == nil {
+		cookie = existing.Value
+	}
+
+	login, err := deviceService.Recognize(user, cookie, r.UserAgent(), clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.DeviceCookie,
+		Value:    login.Cookie,
+		Path:     "/auth/", // Password, single sign-on and device routes
+		MaxAge:   int(auth.DeviceCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode, // Sent on the redirect back from the identity provider
+	})
+
+	return login, nil
+}
+
+// attachDeviceSession links the session of a login to its device, so
+// revoking the device ends it. Without a device the session only ends with a
+// logout or a password change.
+func attachDeviceSession(deviceService *auth.DeviceService, user *users.User, deviceID uuid.UUID, tokens *auth.TokenPair) {
+	if deviceID == uuid.Nil {
+		return
+	}
+	if err := deviceService.AttachSession(deviceID, tokens); err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":     "login_device_session_failed",
+			"user_id":   user.ID.String(),
+			"device_id": deviceID.String(),
+			"error":     err.Error(),
+		}).Error("Failed to attach session to device")
+	}
+}
+
+// newDeviceNotifier emails users when they log in from a new device, so a
+// stolen password does not go unnoticed
+func newDeviceNotifier(mailer mail.Mailer) auth.NewDeviceNotifier {
+	return func(user *users.User, device *auth.Device) {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":     "login_new_device",
+			"user_id":   user.ID.String(),
+			"device_id": device.ID.String(),
+			"ip":        device.LastIP,
+		}).Warn("Login from a new device")
+
+		// The tokens of the login are on their way, the warning follows
+		sendInBackground("login_new_device_email_failed", map[string]interface{}{"user_id": user.ID.String()}, func() error {
+			return mailer.Send(mail.Message{
+				To:      user.Email,
+				Subject: "New sign in to your Shade account",
+				Body: fmt.Sprintf("Hi %s,\n\nYour Shade account was signed in to from a new device:\n\n"+
+					"  %s\n  IP address %s\n  %s\n\n"+
+					"If it was you, there is nothing to do. Otherwise change your password "+
+					"and remove the device from your account settings.\n",
+					user.Name, device.Name, device.LastIP, device.CreatedAt.UTC().Format(time.RFC1123)),
+			})
+		})
+	}
+}
+
+func listDevicesHandler(w http.ResponseWriter, r *http.Request, deviceService *auth.DeviceService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	cookie := ""
+	if existing, err := r.Cookie(auth.DeviceCookie); err == nil {
+		cookie = existing.Value
+	}
+
+	devices, err := deviceService.List(id, cookie)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "device_list_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to list devices")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"devices": devices})
+}
+
+func renameDeviceHandler(w http.ResponseWriter, r *http.Request, deviceService *auth.DeviceService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody auth.RenameDevice
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := deviceService.Rename(id, deviceID, requestBody.Name); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidDeviceName):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, auth.ErrDeviceMissing):
+			http.Error(w, "Device not found", http.StatusNotFound)
+		default:
+			logger.Log.WithFields(map[string]interface{}{
+				"event":     "device_rename_failed",
+				"user_id":   userID,
+				"device_id": deviceID.String(),
+				"error":     err.Error(),
+			}).Error("Failed to rename device")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Device renamed"})
+}
+
+func revokeDeviceHandler(w http.ResponseWriter, r *http.Request, deviceService *auth.DeviceService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := deviceService.Revoke(id, deviceID); err != nil {
+		if errors.Is(err, auth.ErrDeviceMissing) {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":     "device_revoke_failed",
+			"user_id":   userID,
+			"device_id": deviceID.String(),
+			"error":     err.Error(),
+		}).Error("Failed to revoke device")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":     "device_revoked",
+		"user_id":   userID,
+		"device_id": deviceID.String(),
+		"ip":        r.RemoteAddr,
+	}).Info("Device revoked")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Device revoked"})
+}
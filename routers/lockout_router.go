@@ -0,0 +1,86 @@
+package routers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"shade_web_server/core/mail"
+	"shade_web_server/core/trust"
+	"shade_web_server/core/users"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+// registerLockoutRoutes lets platform admins see and lift account lockouts
+func registerLockoutRoutes(r *mux.Router) {
+	r.Handle("/auth/lockouts", middleware.JWTAuthMiddleware(middleware.RequirePlatformAdmin(http.HandlerFunc(listLockoutsHandler)))).Methods("GET")
+	r.Handle("/auth/lockouts/{email}", middleware.JWTAuthMiddleware(middleware.RequirePlatformAdmin(http.HandlerFunc(unlockAccountHandler)))).Methods("DELETE")
+}
+
+// lockNotifier emails the owner of a locked account. Unknown emails are
+// locked too, so guessing cannot reveal which accounts exist, but nobody
+// is told about them.
+func lockNotifier(userService *users.UserService, mailer mail.Mailer) trust.LockNotifier {
+	return func(email string, until time.Time, lockCount int) {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":        "account_locked",
+			"user":         email,
+			"locked_until": until,
+			"lock_count":   lockCount,
+		}).Warn("Account locked after too many failed logins")
+
+		// The lookup runs in the background too: a locked unknown email must
+		// answer as fast as a locked account
+		sendInBackground("account_locked_email_failed", map[string]interface{}{"user": email}, func() error {
+			user, err := userService.GetUserByEmail(email)
+			if err != nil {
+				return nil // Not an account, nobody to tell
+			}
+
+			return mailer.Send(mail.Message{
+				To:      user.Email,
+				Subject: "Your Shade account was locked",
+				Body: fmt.Sprintf("Hi %s,\n\nThere were too many failed attempts to sign in to your Shade account, "+
+					"so it is locked until %s.\n\n"+
+					"If it was not you, somebody may be guessing your password. "+
+					"You can choose a new one from the sign in page once the lock is over.\n",
+					user.Name, until.UTC().Format(time.RFC1123)),
+			})
+		})
+	}
+}
+
+func listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(trust.AccountLocks.LockedAccounts())
+}
+
+func unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	email := mux.Vars(r)["email"]
+
+	if !trust.AccountLocks.Unlock(email) {
+		http.Error(w, "Account is not locked", http.StatusNotFound)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":    "account_unlocked",
+		"user":     email,
+		"admin_id": r.Context().Value(middleware.UserIDKey),
+		"ip":       r.RemoteAddr,
+	}).Info("Account unlocked by an admin")
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,20 @@
+package routers
+
+import (
+	"shade_web_server/infrastructure/logger"
+)
+
+// sendInBackground runs send, which emails somebody, without making the
+// request wait for the mail server. The response is gone by the time it
+// fails, so failures are only logged, as event with fields.
+func sendInBackground(event string, fields map[string]interface{}, send func() error) {
+	go func() {
+		if err := send(); err != nil {
+			entry := map[string]interface{}{"event": event, "error": err.Error()}
+			for key, value := range fields {
+				entry[key] = value
+			}
+			logger.Log.WithFields(entry).Error("Failed to send email")
+		}
+	}()
+}
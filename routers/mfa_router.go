@@ -0,0 +1,293 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/namespace"
+	"shade_web_server/core/trust"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// registerMFARoutes sets up TOTP enrollment and the second login step
+func registerMFARoutes(r *mux.Router, mfaService *auth.MFAService, namespaceService *namespace.NamespaceService, deviceService *auth.DeviceService) {
+	r.HandleFunc("/auth/login/mfa/", func(w http.ResponseWriter, r *http.Request) {
+		mfaLoginHandler(w, r, mfaService, namespaceService, deviceService)
+	}).Methods("POST")
+
+	r.Handle("/auth/mfa/enroll/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mfaEnrollHandler(w, r, mfaService)
+	}))).Methods("POST")
+
+	r.Handle("/auth/mfa/confirm/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mfaConfirmHandler(w, r, mfaService)
+	}))).Methods("POST")
+
+	r.Handle("/auth/mfa/disable/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mfaDisableHandler(w, r, mfaService)
+	}))).Methods("POST")
+}
+
+// mfaFailureKey tracks failed codes per account, so rotating IPs does not help guessing
+func mfaFailureKey(userID string) string {
+	return "mfa:" + userID
+}
+
+// mfaThrottled reports whether the IP or the account failed too many codes.
+// A 6-digit code is easy to guess without a limit on attempts.
+func mfaThrottled(clientIP, userID string) bool {
+	ipPenalized, _ := trust.FailedTracker.ShouldPenalize(clientIP)
+	userPenalized, _ := trust.FailedTracker.ShouldPenalize(mfaFailureKey(userID))
+	return ipPenalized || userPenalized
+}
+
+func mfaLoginHandler(w http.ResponseWriter, r *http.Request, mfaService *auth.MFAService, namespaceService *namespace.NamespaceService, deviceService *auth.DeviceService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	var requestBody auth.MFALogin
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := trust.GetIPFromRequest(r)
+
+	challenge, err := mfaService.ParseChallenge(requestBody.MFAToken)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "login_mfa_invalid_token",
+			"ip":    clientIP,
+			"error": err.Error(),
+		}).Warn("Invalid two-factor challenge token")
+		http.Error(w, "Invalid or expired mfa_token", http.StatusUnauthorized)
+		return
+	}
+
+	userKey := mfaFailureKey(challenge.UserID)
+	if mfaThrottled(clientIP, challenge.UserID) {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "login_mfa_throttled",
+			"user_id": challenge.UserID,
+			"ip":      clientIP,
+		}).Warn("Too many failed two-factor attempts")
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	err = mfaService.Verify(challenge.UserID, requestBody.Code, requestBody.RecoveryCode)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidMFACode) {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "login_mfa_error",
+				"user_id": challenge.UserID,
+				"ip":      clientIP,
+				"error":   err.Error(),
+			}).Error("Failed to verify two-factor code")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		failedCount := trust.FailedTracker.RecordFailure(clientIP)
+		trust.FailedTracker.RecordFailure(userKey)
+
+		logger.Log.WithFields(map[string]interface{}{
+			"event":           "login_mfa_failed",
+			"user_id":         challenge.UserID,
+			"ip":              clientIP,
+			"recovery_code":   requestBody.Code == "",
+			"failed_attempts": failedCount,
+		}).Warn("Invalid two-factor code")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	trust.FailedTracker.ResetFailures(clientIP)
+	trust.FailedTracker.ResetFailures(userKey)
+
+	user, tokens, err := mfaService.CompleteChallenge(challenge)
+	if err != nil {
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			http.Error(w, "Account disabled", http.StatusForbidden)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "login_token_error",
+			"user_id": challenge.UserID,
+			"ip":      clientIP,
+			"error":   err.Error(),
+		}).Error("Failed to issue tokens")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	deviceID := evaluateLoginTrust(w, r, user, deviceService, true)
+	attachDeviceSession(deviceService, user, deviceID, tokens)
+
+	ensureNamespace(user.Namespace(), namespaceService)
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":         "login_success",
+		"user_id":       challenge.UserID,
+		"ip":            clientIP,
+		"method":        r.Method,
+		"path":          r.URL.Path,
+		"mfa":           true,
+		"recovery_code": requestBody.Code == "",
+	}).Info("Login successful")
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func mfaEnrollHandler(w http.ResponseWriter, r *http.Request, mfaService *auth.MFAService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := mfaService.Auth.UserService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	enrollment, err := mfaService.BeginEnrollment(user)
+	if err != nil {
+		if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "mfa_enroll_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to start two-factor enrollment")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "mfa_enroll_started",
+		"user_id": userID,
+		"ip":      r.RemoteAddr,
+	}).Info("Two-factor enrollment started")
+
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func mfaConfirmHandler(w http.ResponseWriter, r *http.Request, mfaService *auth.MFAService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	var requestBody auth.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if mfaThrottled(trust.GetIPFromRequest(r), userID) {
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	recoveryCodes, err := mfaService.ConfirmEnrollment(userID, requestBody.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			trust.FailedTracker.RecordFailure(trust.GetIPFromRequest(r))
+			trust.FailedTracker.RecordFailure(mfaFailureKey(userID))
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+		case errors.Is(err, auth.ErrMFAAlreadyEnabled):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, auth.ErrMFANotEnabled):
+			http.Error(w, "No pending two-factor enrollment", http.StatusBadRequest)
+		default:
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "mfa_confirm_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to confirm two-factor enrollment")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "mfa_enabled",
+		"user_id": userID,
+		"ip":      r.RemoteAddr,
+	}).Info("Two-factor authentication enabled")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+func mfaDisableHandler(w http.ResponseWriter, r *http.Request, mfaService *auth.MFAService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	var requestBody auth.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if mfaThrottled(trust.GetIPFromRequest(r), userID) {
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	err := mfaService.Disable(userID, requestBody.Code, requestBody.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			trust.FailedTracker.RecordFailure(trust.GetIPFromRequest(r))
+			trust.FailedTracker.RecordFailure(mfaFailureKey(userID))
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+		case errors.Is(err, auth.ErrMFANotEnabled):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "mfa_disable_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to disable two-factor authentication")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "mfa_disabled",
+		"user_id": userID,
+		"ip":      r.RemoteAddr,
+	}).Info("Two-factor authentication disabled")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,175 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/namespace"
+	"shade_web_server/core/trust"
+	"shade_web_server/infrastructure/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// oidcStateCookie carries the signed state between /auth/oidc/login and the callback
+const oidcStateCookie = "shade_oidc_state"
+
+// registerOIDCRoutes sets up single sign-on through an OpenID Connect provider
+func registerOIDCRoutes(r *mux.Router, oidcService *auth.OIDCService, mfaService *auth.MFAService, namespaceService *namespace.NamespaceService, deviceService *auth.DeviceService) {
+	r.HandleFunc("/auth/oidc/login", func(w http.ResponseWriter, r *http.Request) {
+		oidcLoginHandler(w, r, oidcService)
+	}).Methods("GET")
+
+	r.HandleFunc("/auth/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		oidcCallbackHandler(w, r, oidcService, mfaService, namespaceService, deviceService)
+	}).Methods("GET")
+}
+
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request, oidcService *auth.OIDCService) {
+	authURL, stateToken, err := oidcService.Begin(r.Context())
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "oidc_login_failed",
+			"ip":    trust.GetIPFromRequest(r),
+			"error": err.Error(),
+		}).Error("Failed to start single sign-on")
+		http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateToken,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(auth.OIDCStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode, // Sent on the top-level redirect back from the provider
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request, oidcService *auth.OIDCService, mfaService *auth.MFAService, namespaceService *namespace.NamespaceService, deviceService *auth.DeviceService) {
+	clientIP := trust.GetIPFromRequest(r)
+	query := r.URL.Query()
+
+	// The state cookie is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/auth/oidc/", MaxAge: -1, HttpOnly: true})
+
+	if providerError := query.Get("error"); providerError != "" {
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "oidc_login_denied",
+			"ip":    clientIP,
+			"error": providerError,
+		}).Warn("Identity provider returned an error")
+		redirectToApp(w, r, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		redirectToApp(w, r, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	user, created, err := oidcService.Complete(r.Context(), cookie.Value, query.Get("state"), query.Get("code"))
+	if err != nil {
+		reason := "server_error"
+		switch {
+		case errors.Is(err, auth.ErrInvalidOIDCState):
+			reason = "invalid_state"
+		case errors.Is(err, auth.ErrOIDCEmailNotVerified):
+			reason = "email_not_verified"
+		case errors.Is(err, auth.ErrOIDCAccountNotVerified):
+			reason = "account_not_verified"
+		case errors.Is(err, auth.ErrInvalidIDToken):
+			reason = "invalid_token"
+		case errors.Is(err, auth.ErrAccountDisabled):
+			reason = "account_disabled"
+		}
+
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "oidc_login_failed",
+			"ip":    clientIP,
+			"error": err.Error(),
+		}).Warn("Single sign-on failed")
+		redirectToApp(w, r, url.Values{"error": {reason}})
+		return
+	}
+
+	userID := user.ID.String()
+
+	// Each user has a unique namespace defined by their UID
+	ensureNamespace(user.Namespace(), namespaceService)
+
+	// A second factor configured in Shade still applies
+	mfaEnabled, err := mfaService.IsEnabled(userID)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "login_mfa_lookup_failed",
+			"user_id": userID,
+			"ip":      clientIP,
+			"error":   err.Error(),
+		}).Error("Failed to look up two-factor settings")
+		redirectToApp(w, r, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	if mfaEnabled {
+		challenge, err := mfaService.CreateChallenge(user)
+		if err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "login_mfa_challenge_failed",
+				"user_id": userID,
+				"ip":      clientIP,
+				"error":   err.Error(),
+			}).Error("Failed to create two-factor challenge")
+			redirectToApp(w, r, url.Values{"error": {"server_error"}})
+			return
+		}
+
+		redirectToApp(w, r, url.Values{"mfa_token": {challenge.MFAToken}})
+		return
+	}
+
+	deviceID := evaluateLoginTrust(w, r, user, deviceService, false)
+
+	tokens, err := mfaService.Auth.IssueTokens(user)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "login_token_error",
+			"user_id": userID,
+			"ip":      clientIP,
+			"error":   err.Error(),
+		}).Error("Failed to issue tokens")
+		redirectToApp(w, r, url.Values{"error": {"server_error"}})
+		return
+	}
+	attachDeviceSession(deviceService, user, deviceID, tokens)
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "login_success",
+		"user_id": userID,
+		"ip":      clientIP,
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"sso":     true,
+		"created": created,
+	}).Info("Login successful")
+
+	redirectToApp(w, r, url.Values{
+		"token":         {tokens.AccessToken},
+		"refresh_token": {tokens.RefreshToken},
+		"expires_in":    {strconv.FormatInt(tokens.ExpiresIn, 10)},
+	})
+}
+
+// redirectToApp sends the browser back to the frontend. Results travel in the
+// URL fragment, which browsers never send to servers or put in Referer headers.
+func redirectToApp(w http.ResponseWriter, r *http.Request, values url.Values) {
+	http.Redirect(w, r, appBaseURL()+"/login/sso#"+values.Encode(), http.StatusFound)
+}
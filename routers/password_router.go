@@ -0,0 +1,129 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/trust"
+	"shade_web_server/infrastructure/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// registerPasswordRoutes sets up the forgotten password flow
+func registerPasswordRoutes(r *mux.Router, resetService *auth.PasswordResetService) {
+	r.HandleFunc("/auth/password/forgot/", func(w http.ResponseWriter, r *http.Request) {
+		forgotPasswordHandler(w, r, resetService)
+	}).Methods("POST")
+
+	r.HandleFunc("/auth/password/reset/", func(w http.ResponseWriter, r *http.Request) {
+		resetPasswordHandler(w, r, resetService)
+	}).Methods("POST")
+}
+
+// resetRequestKeys count reset requests per IP and per email, so the endpoint
+// cannot flood an inbox or keep the mailer busy
+func resetRequestKeys(clientIP, email string) []string {
+	return []string{
+		"password-reset:ip:" + clientIP,
+		"password-reset:email:" + strings.ToLower(strings.TrimSpace(email)),
+	}
+}
+
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request, resetService *auth.PasswordResetService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	var requestBody auth.ForgotPassword
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil || requestBody.Email == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := trust.GetIPFromRequest(r)
+
+	// Unknown emails are throttled too, so a 429 tells nothing about the account
+	keys := resetRequestKeys(clientIP, requestBody.Email)
+	for _, key := range keys {
+		if penalized, _ := trust.FailedTracker.ShouldPenalize(key); penalized {
+			logger.Log.WithFields(map[string]interface{}{
+				"event": "password_reset_throttled",
+				"user":  requestBody.Email,
+				"ip":    clientIP,
+			}).Warn("Too many password reset requests")
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+	for _, key := range keys {
+		trust.FailedTracker.RecordFailure(key)
+	}
+
+	// Send the email in the background, so the response time does not reveal
+	// whether the account exists
+	email := requestBody.Email
+	sendInBackground("password_reset_request_failed", map[string]interface{}{"user": email, "ip": clientIP}, func() error {
+		return resetService.RequestReset(email)
+	})
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event": "password_reset_requested",
+		"user":  requestBody.Email,
+		"ip":    clientIP,
+	}).Info("Password reset requested")
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If the email belongs to an account, a reset link has been sent",
+	})
+}
+
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request, resetService *auth.PasswordResetService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	var requestBody auth.ResetPassword
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil || requestBody.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := trust.GetIPFromRequest(r)
+
+	err := resetService.ResetPassword(requestBody.Token, requestBody.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, auth.ErrInvalidResetToken):
+			trust.FailedTracker.RecordFailure(clientIP)
+			logger.Log.WithFields(map[string]interface{}{
+				"event": "password_reset_invalid_token",
+				"ip":    clientIP,
+			}).Warn("Invalid password reset token")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			logger.Log.WithFields(map[string]interface{}{
+				"event": "password_reset_failed",
+				"ip":    clientIP,
+				"error": err.Error(),
+			}).Error("Failed to reset password")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event": "password_reset_success",
+		"ip":    clientIP,
+	}).Info("Password reset, existing sessions revoked")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password updated, please log in again"})
+}
@@ -0,0 +1,216 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/trust"
+	"shade_web_server/core/users"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// registerProfileRoutes sets up self-service changes to the caller's account
+func registerProfileRoutes(r *mux.Router, userService *users.UserService, authService *auth.AuthService, verificationService *auth.EmailVerificationService) {
+	r.Handle("/users/me", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		updateProfileHandler(w, r, userService, authService, verificationService)
+	}))).Methods("PATCH")
+
+	r.Handle("/users/me/password", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		changePasswordHandler(w, r, authService)
+	}))).Methods("POST")
+}
+
+// passwordFailureKey tracks wrong current passwords per account, so a stolen
+// session cannot be used to guess the password
+func passwordFailureKey(userID string) string {
+	return "password:" + userID
+}
+
+// checkCurrentPassword verifies the password of a logged in user, throttled
+// like logins. It writes the error response and returns false on failure.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, authService *auth.AuthService, user *users.User, password string) bool {
+	clientIP := trust.GetIPFromRequest(r)
+	userKey := passwordFailureKey(user.ID.String())
+
+	if penalized, _ := trust.FailedTracker.ShouldPenalize(userKey); penalized {
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	if _, err := authService.AuthenticateUser(user.Email, password); err != nil {
+		failedCount := trust.FailedTracker.RecordFailure(userKey)
+		logger.Log.WithFields(map[string]interface{}{
+			"event":           "current_password_failed",
+			"user_id":         user.ID.String(),
+			"ip":              clientIP,
+			"failed_attempts": failedCount,
+		}).Warn("Wrong current password")
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+
+	trust.FailedTracker.ResetFailures(userKey)
+	return true
+}
+
+func updateProfileHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, authService *auth.AuthService, verificationService *auth.EmailVerificationService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"` // Required to change the email
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if requestBody.Name != nil && strings.TrimSpace(*requestBody.Name) == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if requestBody.Email != nil && !strings.Contains(*requestBody.Email, "@") {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// The email receives password reset links, so whoever changes it must
+	// know the password and not just hold a session
+	if requestBody.Email != nil && *requestBody.Email != user.Email {
+		if !checkCurrentPassword(w, r, authService, user, requestBody.CurrentPassword) {
+			return
+		}
+	}
+
+	updated, emailChanged, err := userService.UpdateProfile(id, requestBody.Name, requestBody.Email)
+	if err != nil {
+		if errors.Is(err, users.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "profile_update_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to update profile")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if emailChanged {
+		// Links sent to the previous address must not verify the new one,
+		// even when the new email is rate limited
+		if err := verificationService.EmailChanged(id); err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "verification_invalidate_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to invalidate verification links")
+		}
+
+		go func() {
+			if err := verificationService.SendVerification(updated); err != nil {
+				logger.Log.WithFields(map[string]interface{}{
+					"event":   "verification_email_failed",
+					"user_id": userID,
+					"error":   err.Error(),
+				}).Error("Failed to send verification email")
+			}
+		}()
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":         "profile_updated",
+		"user_id":       userID,
+		"ip":            r.RemoteAddr,
+		"email_changed": emailChanged,
+	}).Info("Profile updated")
+
+	json.NewEncoder(w).Encode(updated)
+}
+
+func changePasswordHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := authService.UserService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, authService, user, requestBody.CurrentPassword) {
+		return
+	}
+
+	tokens, err := authService.ChangePassword(id, requestBody.CurrentPassword, requestBody.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		default:
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "password_change_failed",
+				"user_id": userID,
+				"error":   err.Error(),
+			}).Error("Failed to change password")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "password_changed",
+		"user_id": userID,
+		"ip":      r.RemoteAddr,
+	}).Info("Password changed, other sessions revoked")
+
+	// The old tokens were revoked with every other session
+	json.NewEncoder(w).Encode(tokens)
+}
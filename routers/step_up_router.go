@@ -0,0 +1,126 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/trust"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// registerStepUpRoutes lets logged in users prove it is them again, for
+// routes that ask for a step-up when the trust score of a request is medium
+func registerStepUpRoutes(r *mux.Router, authService *auth.AuthService, mfaService *auth.MFAService) {
+	r.Handle("/auth/step-up", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stepUpHandler(w, r, authService, mfaService)
+	}))).Methods("POST")
+}
+
+func stepUpHandler(w http.ResponseWriter, r *http.Request, authService *auth.AuthService, mfaService *auth.MFAService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody auth.StepUp
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := authService.UserService.GetUserByID(id)
+	if err != nil || !user.Active() {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var method string
+	switch {
+	case requestBody.Password != "":
+		if !checkCurrentPassword(w, r, authService, user, requestBody.Password) {
+			return
+		}
+		method = auth.StepUpMethodPassword
+	case requestBody.Code != "" || requestBody.RecoveryCode != "":
+		if !checkStepUpCode(w, r, mfaService, userID, requestBody) {
+			return
+		}
+		method = auth.StepUpMethodTOTP
+	default:
+		http.Error(w, "password or code is required", http.StatusBadRequest)
+		return
+	}
+
+	elevated, err := authService.GenerateElevatedToken(user, method)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "step_up_token_error",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to issue elevated token")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":   "step_up_success",
+		"user_id": userID,
+		"method":  method,
+		"ip":      trust.GetIPFromRequest(r),
+	}).Info("Step-up authentication succeeded")
+
+	json.NewEncoder(w).Encode(elevated)
+}
+
+// checkStepUpCode verifies a two-factor code, throttled like the login. It
+// writes the error response and returns false on failure.
+func checkStepUpCode(w http.ResponseWriter, r *http.Request, mfaService *auth.MFAService, userID string, requestBody auth.StepUp) bool {
+	clientIP := trust.GetIPFromRequest(r)
+
+	if mfaThrottled(clientIP, userID) {
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	err := mfaService.Verify(userID, requestBody.Code, requestBody.RecoveryCode)
+	switch {
+	case err == nil:
+		trust.FailedTracker.ResetFailures(mfaFailureKey(userID))
+		return true
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		failedCount := trust.FailedTracker.RecordFailure(clientIP)
+		trust.FailedTracker.RecordFailure(mfaFailureKey(userID))
+		logger.Log.WithFields(map[string]interface{}{
+			"event":           "step_up_mfa_failed",
+			"user_id":         userID,
+			"ip":              clientIP,
+			"failed_attempts": failedCount,
+		}).Warn("Invalid two-factor code")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrMFANotEnabled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "step_up_mfa_error",
+			"user_id": userID,
+			"ip":      clientIP,
+			"error":   err.Error(),
+		}).Error("Failed to verify two-factor code")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return false
+}
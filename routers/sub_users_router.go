@@ -0,0 +1,321 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/core/users"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// registerSubUserRoutes sets up management of a root user's sub-users. Only
+// the root user manages them, sub-users cannot whatever their role.
+func registerSubUserRoutes(r *mux.Router, userService *users.UserService, authService *auth.AuthService, verificationService *auth.EmailVerificationService) {
+	manage := func(handler http.HandlerFunc) http.Handler {
+		return middleware.JWTAuthMiddleware(middleware.RequireVerifiedEmail(middleware.RequireRootUser(middleware.RequirePermission(users.PermUsersManage)(handler))))
+	}
+
+	r.Handle("/users/sub-users/create/", manage(func(w http.ResponseWriter, r *http.Request) {
+		createSubUserHandler(w, r, userService, verificationService)
+	})).Methods("POST")
+
+	r.Handle("/users/sub-users/", manage(func(w http.ResponseWriter, r *http.Request) {
+		listSubUsersHandler(w, r, userService)
+	})).Methods("GET")
+
+	r.Handle("/users/sub-users/{id}", manage(func(w http.ResponseWriter, r *http.Request) {
+		updateSubUserHandler(w, r, userService, authService)
+	})).Methods("PATCH")
+
+	r.Handle("/users/sub-users/{id}/disable", manage(func(w http.ResponseWriter, r *http.Request) {
+		setSubUserDisabledHandler(w, r, userService, authService, true)
+	})).Methods("POST")
+
+	r.Handle("/users/sub-users/{id}/enable", manage(func(w http.ResponseWriter, r *http.Request) {
+		setSubUserDisabledHandler(w, r, userService, authService, false)
+	})).Methods("POST")
+
+	r.Handle("/users/sub-users/{id}", manage(func(w http.ResponseWriter, r *http.Request) {
+		deleteSubUserHandler(w, r, userService, authService)
+	})).Methods("DELETE")
+}
+
+// rootUserID returns the root user whose sub-users the caller manages.
+func rootUserID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.Context().Value(middleware.NamespaceKey).(string))
+}
+
+// subUserTarget parses the sub-user in the path. Callers cannot act on their
+// own account through these routes, so the root user cannot lock themselves out.
+func subUserTarget(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	root, err := rootUserID(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sub-user ID", http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if id.String() == r.Context().Value(middleware.UserIDKey).(string) {
+		http.Error(w, "Cannot manage your own account here", http.StatusForbidden)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return root, id, true
+}
+
+// writeSubUserError maps service errors to responses.
+func writeSubUserError(w http.ResponseWriter, err error, event string, fields map[string]interface{}) {
+	switch {
+	case errors.Is(err, users.ErrSubUserNotFound):
+		http.Error(w, "Sub-user not found", http.StatusNotFound)
+	case errors.Is(err, users.ErrInvalidRole), errors.Is(err, users.ErrRootUserNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, users.ErrEmailTaken):
+		http.Error(w, "Email already in use", http.StatusConflict)
+	default:
+		fields["event"] = event
+		fields["error"] = err.Error()
+		logger.Log.WithFields(fields).Error("Sub-user operation failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// Handler to create a new sub-user
+func createSubUserHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, verificationService *auth.EmailVerificationService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	var input struct {
+		RootUserID string `json:"root_user_id"` // Optional, must be the caller's root user
+		Name       string `json:"name"`         // Name of the sub-user
+		Email      string `json:"email"`        // Email of the sub-user
+		Password   string `json:"password"`     // Password of the sub-user
+		Role       string `json:"role"`         // admin, developer or viewer (default)
+	}
+
+	// Decode JSON body into input struct
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Name == "" || input.Email == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if len(input.Password) < auth.MinPasswordLength {
+		http.Error(w, auth.ErrWeakPassword.Error(), http.StatusBadRequest)
+		return
+	}
+
+	root, err := rootUserID(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Sub-users can only be added to the caller's own account
+	if input.RootUserID != "" && input.RootUserID != root.String() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = string(users.RoleViewer)
+	}
+
+	existing, err := userService.UserRepo.FindByEmail(input.Email)
+	if err != nil {
+		writeSubUserError(w, err, "sub_user_create_failed", map[string]interface{}{"root_user_id": root.String()})
+		return
+	}
+	if existing != nil {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
+
+	// Create the sub-user
+	subUser, err := userService.CreateSubUser(root, input.Name, input.Email, input.Password, users.Role(input.Role))
+	if err != nil {
+		writeSubUserError(w, err, "sub_user_create_failed", map[string]interface{}{"root_user_id": root.String()})
+		return
+	}
+
+	// Sub-users confirm their email like everybody else before deploying
+	go func() {
+		if err := verificationService.SendVerification(subUser); err != nil {
+			logger.Log.WithFields(map[string]interface{}{
+				"event":   "verification_email_failed",
+				"user_id": subUser.ID.String(),
+				"error":   err.Error(),
+			}).Error("Failed to send verification email")
+		}
+	}()
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":        "sub_user_created",
+		"user_id":      subUser.ID.String(),
+		"root_user_id": root.String(),
+		"role":         subUser.Role,
+		"by":           r.Context().Value(middleware.UserIDKey),
+	}).Info("Sub-user created")
+
+	// Return the created sub-user as JSON, without the password hash
+	subUser.Password = ""
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(subUser)
+}
+
+func listSubUsersHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	root, err := rootUserID(r)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	subUsers, err := userService.GetSubUsers(root)
+	if err != nil {
+		writeSubUserError(w, err, "sub_user_list_failed", map[string]interface{}{"root_user_id": root.String()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"sub_users": subUsers})
+}
+
+func updateSubUserHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	root, id, ok := subUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string     `json:"name"`
+		Role *users.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || (input.Name != nil && *input.Name == "") {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	previous, err := userService.GetSubUser(root, id)
+	if err != nil {
+		writeSubUserError(w, err, "sub_user_update_failed", map[string]interface{}{"user_id": id.String()})
+		return
+	}
+
+	subUser, err := userService.UpdateSubUser(root, id, input.Name, input.Role)
+	if err != nil {
+		writeSubUserError(w, err, "sub_user_update_failed", map[string]interface{}{"user_id": id.String()})
+		return
+	}
+
+	// Roles travel in the tokens, so end the sessions that carry the old one
+	if subUser.Role != previous.Role {
+		if err := authService.LogoutAll(id.String()); err != nil {
+			writeSubUserError(w, err, "sub_user_update_failed", map[string]interface{}{"user_id": id.String()})
+			return
+		}
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":        "sub_user_updated",
+		"user_id":      id.String(),
+		"root_user_id": root.String(),
+		"role":         subUser.Role,
+		"by":           r.Context().Value(middleware.UserIDKey),
+	}).Info("Sub-user updated")
+
+	json.NewEncoder(w).Encode(subUser)
+}
+
+func setSubUserDisabledHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, authService *auth.AuthService, disabled bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	root, id, ok := subUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	subUser, err := userService.SetSubUserDisabled(root, id, disabled)
+	if err != nil {
+		writeSubUserError(w, err, "sub_user_status_failed", map[string]interface{}{"user_id": id.String()})
+		return
+	}
+
+	// A disabled sub-user is logged out everywhere
+	if disabled {
+		if err := authService.LogoutAll(id.String()); err != nil {
+			writeSubUserError(w, err, "sub_user_status_failed", map[string]interface{}{"user_id": id.String()})
+			return
+		}
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":        "sub_user_status_changed",
+		"user_id":      id.String(),
+		"root_user_id": root.String(),
+		"disabled":     disabled,
+		"by":           r.Context().Value(middleware.UserIDKey),
+	}).Info("Sub-user status changed")
+
+	json.NewEncoder(w).Encode(subUser)
+}
+
+func deleteSubUserHandler(w http.ResponseWriter, r *http.Request, userService *users.UserService, authService *auth.AuthService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	root, id, ok := subUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := userService.GetSubUser(root, id); err != nil {
+		writeSubUserError(w, err, "sub_user_delete_failed", map[string]interface{}{"user_id": id.String()})
+		return
+	}
+
+	// Revoke the sessions first, the access tokens would otherwise outlive the user
+	if err := authService.LogoutAll(id.String()); err != nil {
+		writeSubUserError(w, err, "sub_user_delete_failed", map[string]interface{}{"user_id": id.String()})
+		return
+	}
+
+	if err := userService.DeleteSubUser(root, id); err != nil {
+		writeSubUserError(w, err, "sub_user_delete_failed", map[string]interface{}{"user_id": id.String()})
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":        "sub_user_deleted",
+		"user_id":      id.String(),
+		"root_user_id": root.String(),
+		"by":           r.Context().Value(middleware.UserIDKey),
+	}).Info("Sub-user deleted")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Sub-user deleted"})
+}
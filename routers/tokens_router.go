@@ -0,0 +1,153 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"shade_web_server/core/auth"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// registerTokenRoutes sets up management of personal access tokens
+func registerTokenRoutes(r *mux.Router, tokenService *auth.PersonalAccessTokenService) {
+	r.Handle("/auth/tokens/", middleware.JWTAuthMiddleware(middleware.RequireVerifiedEmail(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		createTokenHandler(w, r, tokenService)
+	})))).Methods("POST")
+
+	r.Handle("/auth/tokens/", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listTokensHandler(w, r, tokenService)
+	}))).Methods("GET")
+
+	r.Handle("/auth/tokens/{id}", middleware.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revokeTokenHandler(w, r, tokenService)
+	}))).Methods("DELETE")
+}
+
+func createTokenHandler(w http.ResponseWriter, r *http.Request, tokenService *auth.PersonalAccessTokenService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody auth.CreatePersonalAccessToken
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	plain, token, err := tokenService.Create(id, requestBody)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidTokenRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "personal_token_create_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to create personal access token")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":    "personal_token_created",
+		"user_id":  userID,
+		"token_id": token.ID.String(),
+		"scopes":   token.Scopes,
+		"ip":       r.RemoteAddr,
+	}).Info("Personal access token created")
+
+	// The token is only ever shown in this response
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":   plain,
+		"details": token,
+	})
+}
+
+func listTokensHandler(w http.ResponseWriter, r *http.Request, tokenService *auth.PersonalAccessTokenService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := tokenService.List(id)
+	if err != nil {
+		logger.Log.WithFields(map[string]interface{}{
+			"event":   "personal_token_list_failed",
+			"user_id": userID,
+			"error":   err.Error(),
+		}).Error("Failed to list personal access tokens")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens})
+}
+
+func revokeTokenHandler(w http.ResponseWriter, r *http.Request, tokenService *auth.PersonalAccessTokenService) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value(middleware.UserIDKey).(string)
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tokenID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := tokenService.Revoke(id, tokenID); err != nil {
+		if errors.Is(err, auth.ErrPersonalAccessTokenMissing) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.WithFields(map[string]interface{}{
+			"event":    "personal_token_revoke_failed",
+			"user_id":  userID,
+			"token_id": tokenID.String(),
+			"error":    err.Error(),
+		}).Error("Failed to revoke personal access token")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"event":    "personal_token_revoked",
+		"user_id":  userID,
+		"token_id": tokenID.String(),
+		"ip":       r.RemoteAddr,
+	}).Info("Personal access token revoked")
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked"})
+}
@@ -16,6 +16,7 @@ import (
 func InitializeTrustRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Handle("/trust/score", middleware.TrustMiddleware(http.HandlerFunc(getTrustScoreHandler))).Methods("GET")
+	r.Handle("/trust/geoip/cache", middleware.JWTAuthMiddleware(middleware.RequirePlatformAdmin(http.HandlerFunc(getGeoIPCacheStatsHandler)))).Methods("GET")
 	return r
 }
 
@@ -53,3 +54,19 @@ func getTrustScoreHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
+
+// getGeoIPCacheStatsHandler reports the hits and misses of the GeoIP cache
+func getGeoIPCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	cache, ok := trust.DefaultTrustEngine.Resolver().(*trust.CachingResolver)
+	if !ok {
+		http.Error(w, "GeoIP cache disabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cache.Stats())
+}
@@ -3,59 +3,148 @@ package routers
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"net/url"
+	"shade_web_server/core/accounts"
+	"shade_web_server/core/auth"
+	"shade_web_server/core/containers"
+	"shade_web_server/core/mail"
+	"shade_web_server/core/namespace"
 	"shade_web_server/core/users"
+	"shade_web_server/infrastructure/logger"
+	"shade_web_server/middleware"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"k8s.io/client-go/kubernetes"
+	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
 var userService *users.UserService
 
 // Sets up all the routes, accepting the DB connection as an argument
-func InitializeUsersRouter(dbConn *sql.DB) *mux.Router {
+func InitializeUsersRouter(dbConn *sql.DB, clientset *kubernetes.Clientset, metrics *metrics.Clientset, keys *auth.KeyProvider, mailer mail.Mailer, deletionGracePeriod time.Duration) *mux.Router {
 	// Initialize the UserRepository and UserService
 	repo := users.NewMySQLUserRepository(dbConn) // Pass dbConn here
 	userService = users.NewUserService(repo)
+	authService := auth.NewAuthService(userService, auth.NewMySQLRefreshTokenRepository(dbConn), auth.NewMySQLRevocationStore(dbConn), keys)
+	authService.SetRehashFailureHandler(logRehashFailure)
+	verificationService := auth.NewEmailVerificationService(auth.NewMySQLEmailVerificationRepository(dbConn), userService, mailer, appBaseURL())
+	tokenService := auth.NewPersonalAccessTokenService(auth.NewMySQLPersonalAccessTokenRepository(dbConn), userService)
+	deletionService := accounts.NewDeletionService(
+		accounts.NewMySQLDeletionRepository(dbConn),
+		authService,
+		tokenService,
+		containers.NewKubernetesContainerRepository(clientset, metrics),
+		namespace.NewNamespaceService(namespace.NewKubernetesNamespaceRepository(clientset)),
+		mailer,
+		appBaseURL(),
+		deletionGracePeriod,
+	)
+
+	// Tear down deleted accounts once their grace period is over
+	go startDeletionWorker(deletionService)
 
 	r := mux.NewRouter()
 
+	// Account management is off limits for personal access tokens
+	r.Use(middleware.RejectPersonalAccessTokens)
+
+	// Direct access to any account is for platform admins only, users sign up through /auth/signup/
+	admin := func(handler http.HandlerFunc) http.Handler {
+		return middleware.JWTAuthMiddleware(middleware.RequirePlatformAdmin(handler))
+	}
+
 	// Define routes and pass userService to the handlers
-	r.HandleFunc("/users/", getUsers).Methods("GET")
-	r.HandleFunc("/users/create/", createUserHandler).Methods("POST")
-	r.HandleFunc("/users/sub-users/create/", createSubUserHandler).Methods("POST")
-	r.HandleFunc("/users/{id}", getUserByID).Methods("GET")
-	r.HandleFunc("/users/email/{email}", getUserByEmail).Methods("GET")
+	r.Handle("/users/", admin(getUsers)).Methods("GET")
+	r.Handle("/users/create/", admin(createUserHandler)).Methods("POST")
+	registerSubUserRoutes(r, userService, authService, verificationService)
+	registerProfileRoutes(r, userService, authService, verificationService)
+	registerAccountDeletionRoutes(r, deletionService, authService)
+	r.Handle("/users/{id}", admin(getUserByID)).Methods("GET")
+	r.Handle("/users/email/{email}", admin(getUserByEmail)).Methods("GET")
 
 	return r
 }
 
-// Handler to get all users
+// Handler to list users, one page at a time
 func getUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := userService.GetAllUsers()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	filter, err := parseUserFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := userService.ListUsers(filter)
 	if err != nil {
-		fmt.Printf("%v", err)
+		logger.Log.WithFields(map[string]interface{}{
+			"event": "user_listing_failed",
+			"error": err.Error(),
+		}).Error("Failed to list users")
 		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
 		return
 	}
 
 	// Set the response header for JSON
 	w.Header().Set("Content-Type", "application/json")
-	// Encode the list of users into the response
-	response := map[string]int{"users": users}
-	jsonResponse, err := json.Marshal(response)
+	json.NewEncoder(w).Encode(page)
+}
 
-	if err != nil {
-		http.Error(w, "Failed to get users", http.StatusInternalServerError)
+// parseUserFilter reads the listing parameters: email_prefix, root_user_id,
+// created_after and created_before (RFC 3339), order (asc or desc), cursor and limit.
+func parseUserFilter(query url.Values) (users.UserFilter, error) {
+	filter := users.UserFilter{EmailPrefix: query.Get("email_prefix")}
+	var err error
+
+	if value := query.Get("root_user_id"); value != "" {
+		if filter.RootUserID, err = uuid.Parse(value); err != nil {
+			return filter, errors.New("invalid root_user_id")
+		}
+	}
+	if value := query.Get("created_after"); value != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("invalid created_after, expected RFC 3339")
+		}
+	}
+	if value := query.Get("created_before"); value != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("invalid created_before, expected RFC 3339")
+		}
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("order must be asc or desc")
 	}
 
-	w.Write(jsonResponse)
+	if value := query.Get("cursor"); value != "" {
+		if filter.After, err = users.DecodeCursor(value); err != nil {
+			return filter, err
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 {
+			return filter, errors.New("invalid limit")
+		}
+	}
+
+	return filter, nil
 }
 
 // Handler to create a new user
 func createUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user users.User
+	// The password is never decoded into users.User, which does not serialize it
+	var user auth.Signup
 
 	// Decode JSON body into user struct
 	decoder := json.NewDecoder(r.Body)
@@ -67,6 +156,10 @@ func createUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create the user
 	createdUser, err := userService.CreateUser(user.Name, user.Email, user.Password)
+	if errors.Is(err, users.ErrEmailTaken) {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
@@ -77,42 +170,6 @@ func createUserHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(createdUser)
 }
 
-// Handler to create a new sub-user
-func createSubUserHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		RootUserID string `json:"root_user_id"` // ID of the root user
-		Name       string `json:"name"`         // Name of the sub-user
-		Email      string `json:"email"`        // Email of the sub-user
-		Password   string `json:"password"`     // Password of the sub-user
-	}
-
-	// Decode JSON body into input struct
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&input)
-	if err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
-		return
-	}
-
-	// Parse the root user ID
-	rootUserID, err := uuid.Parse(input.RootUserID)
-	if err != nil {
-		http.Error(w, "Invalid root_user_id", http.StatusBadRequest)
-		return
-	}
-
-	// Create the sub-user
-	subUser, err := userService.CreateSubUser(rootUserID, input.Name, input.Email, input.Password)
-	if err != nil {
-		http.Error(w, "Failed to create sub-user", http.StatusInternalServerError)
-		return
-	}
-
-	// Return the created sub-user as JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(subUser)
-}
-
 // Handler to get a user by ID
 func getUserByID(w http.ResponseWriter, r *http.Request) {
 	// Extract the user ID from the URL